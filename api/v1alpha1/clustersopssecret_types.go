@@ -0,0 +1,151 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterSopsSecretSpec defines the desired state of ClusterSopsSecret.
+type ClusterSopsSecretSpec struct {
+	// NamespaceSelector selects the namespaces a Secret is generated in. An empty selector selects all namespaces.
+	NamespaceSelector metav1.LabelSelector `json:"namespaceSelector"`
+
+	// Metadata allows adding labels and annotations to generated Secrets.
+	// +optional
+	Metadata SopsSecretObjectMeta `json:"metadata,omitempty"`
+
+	// StringData allows specifying Sops-encrypted secret data in string form.
+	// +optional
+	StringData map[string]string `json:"stringData,omitempty"`
+
+	// Data allows specifying Sops-encrypted secret data in base64-encoded form, e.g. for encrypted binary files.
+	// A key must not be specified in both StringData and Data.
+	// +optional
+	Data map[string][]byte `json:"data,omitempty"`
+
+	// Options allows specifying options for entries of StringData or Data, keyed by the entry's key.
+	// +optional
+	Options map[string]SopsSecretEntryOptions `json:"options,omitempty"`
+
+	// Template allows specifying Secret keys whose values are rendered from Go templates after decryption.
+	// See the template of SopsSecrets.
+	// +optional
+	Template map[string]string `json:"template,omitempty"`
+
+	// Type specifies the type of the generated Secrets.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+
+	// FailurePolicy specifies how entries of StringData and Data that cannot be decrypted are handled.
+	// +kubebuilder:default=AllOrNothing
+	// +optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+}
+
+// ClusterSopsSecretStatus defines the observed state of ClusterSopsSecret.
+type ClusterSopsSecretStatus struct {
+	// ObservedGeneration is the generation of the ClusterSopsSecret that was last processed.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastUpdate is the time the status was last updated.
+	// +optional
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	// Conditions represent the latest observations of the ClusterSopsSecret's state.
+	// Known condition types are Ready, Decrypted, SecretSynced and Degraded.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Keys reports the status of each entry of StringData and Data.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Keys []SopsSecretKeyStatus `json:"keys,omitempty"`
+	// DataHash is an HMAC-SHA256 of the data of the generated Secrets keyed with the operator's hash key.
+	// +optional
+	DataHash string `json:"dataHash,omitempty"`
+	// Namespaces reports the status of the Secret generated in each selected namespace.
+	// +listType=map
+	// +listMapKey=namespace
+	// +optional
+	Namespaces []ClusterSopsSecretNamespaceStatus `json:"namespaces,omitempty"`
+}
+
+// ClusterSopsSecretNamespaceStatus defines the observed state of a Secret generated from a ClusterSopsSecret.
+type ClusterSopsSecretNamespaceStatus struct {
+	Namespace string `json:"namespace"`
+	Status    string `json:"status,omitempty"`
+	// Message is a human-readable message describing the last failure.
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// ClusterSopsSecret is the Schema for the clustersopssecrets API.
+// It generates a Secret of the same name in each namespace selected by its namespace selector.
+type ClusterSopsSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterSopsSecretSpec   `json:"spec,omitempty"`
+	Status ClusterSopsSecretStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the ClusterSopsSecret's status.
+func (in *ClusterSopsSecret) GetConditions() []metav1.Condition {
+	return in.Status.Conditions
+}
+
+// SetConditions sets the conditions of the ClusterSopsSecret's status.
+func (in *ClusterSopsSecret) SetConditions(conditions []metav1.Condition) {
+	in.Status.Conditions = conditions
+}
+
+// SetObservedGeneration sets the generation of the ClusterSopsSecret that was last processed.
+func (in *ClusterSopsSecret) SetObservedGeneration(generation int64) {
+	in.Status.ObservedGeneration = generation
+}
+
+// GetLastUpdate returns the time the status of the ClusterSopsSecret was last updated.
+func (in *ClusterSopsSecret) GetLastUpdate() metav1.Time {
+	return in.Status.LastUpdate
+}
+
+// SetLastUpdate sets the time the status of the ClusterSopsSecret was last updated.
+func (in *ClusterSopsSecret) SetLastUpdate(lastUpdate metav1.Time) {
+	in.Status.LastUpdate = lastUpdate
+}
+
+//+kubebuilder:object:root=true
+
+// ClusterSopsSecretList contains a list of ClusterSopsSecret
+type ClusterSopsSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []ClusterSopsSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&ClusterSopsSecret{}, &ClusterSopsSecretList{})
+}
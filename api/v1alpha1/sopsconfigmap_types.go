@@ -0,0 +1,136 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SopsConfigMapSpec defines the desired state of SopsConfigMap.
+type SopsConfigMapSpec struct {
+	// Metadata allows adding labels and annotations to the generated ConfigMap.
+	// +optional
+	Metadata SopsSecretObjectMeta `json:"metadata,omitempty"`
+
+	// StringData allows specifying Sops-encrypted data in string form.
+	// +optional
+	StringData map[string]string `json:"stringData,omitempty"`
+
+	// Data allows specifying Sops-encrypted data in base64-encoded form, e.g. for encrypted binary files.
+	// A key must not be specified in both StringData and Data.
+	// +optional
+	Data map[string][]byte `json:"data,omitempty"`
+
+	// Options allows specifying options for entries of StringData or Data, keyed by the entry's key.
+	// +optional
+	Options map[string]SopsSecretEntryOptions `json:"options,omitempty"`
+
+	// Template allows specifying ConfigMap keys whose values are rendered from Go templates after decryption.
+	// See the template of SopsSecrets.
+	// +optional
+	Template map[string]string `json:"template,omitempty"`
+
+	// FailurePolicy specifies how entries of StringData and Data that cannot be decrypted are handled.
+	// +kubebuilder:default=AllOrNothing
+	// +optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+
+	// Decryption allows specifying the keys used to decrypt the data.
+	// +optional
+	Decryption *SopsSecretDecryption `json:"decryption,omitempty"`
+}
+
+// ConditionTypeConfigMapSynced indicates that the generated ConfigMap matches the decrypted data.
+const ConditionTypeConfigMapSynced = "ConfigMapSynced"
+
+// SopsConfigMapStatus defines the observed state of SopsConfigMap.
+type SopsConfigMapStatus struct {
+	// ObservedGeneration is the generation of the SopsConfigMap that was last processed.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastUpdate is the time the status was last updated.
+	// +optional
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	// Conditions represent the latest observations of the SopsConfigMap's state.
+	// Known condition types are Ready, Decrypted, ConfigMapSynced and Degraded.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Keys reports the status of each entry of StringData and Data.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Keys []SopsSecretKeyStatus `json:"keys,omitempty"`
+	// DataHash is an HMAC-SHA256 of the data of the generated ConfigMap keyed with the operator's hash key.
+	// +optional
+	DataHash string `json:"dataHash,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SopsConfigMap is the Schema for the sopsconfigmaps API.
+// It generates a ConfigMap of the same name from Sops-encrypted data.
+type SopsConfigMap struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SopsConfigMapSpec   `json:"spec,omitempty"`
+	Status SopsConfigMapStatus `json:"status,omitempty"`
+}
+
+// GetConditions returns the conditions of the SopsConfigMap's status.
+func (in *SopsConfigMap) GetConditions() []metav1.Condition {
+	return in.Status.Conditions
+}
+
+// SetConditions sets the conditions of the SopsConfigMap's status.
+func (in *SopsConfigMap) SetConditions(conditions []metav1.Condition) {
+	in.Status.Conditions = conditions
+}
+
+// SetObservedGeneration sets the generation of the SopsConfigMap that was last processed.
+func (in *SopsConfigMap) SetObservedGeneration(generation int64) {
+	in.Status.ObservedGeneration = generation
+}
+
+// GetLastUpdate returns the time the status of the SopsConfigMap was last updated.
+func (in *SopsConfigMap) GetLastUpdate() metav1.Time {
+	return in.Status.LastUpdate
+}
+
+// SetLastUpdate sets the time the status of the SopsConfigMap was last updated.
+func (in *SopsConfigMap) SetLastUpdate(lastUpdate metav1.Time) {
+	in.Status.LastUpdate = lastUpdate
+}
+
+//+kubebuilder:object:root=true
+
+// SopsConfigMapList contains a list of SopsConfigMap
+type SopsConfigMapList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SopsConfigMap `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SopsConfigMap{}, &SopsConfigMapList{})
+}
@@ -0,0 +1,343 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// entryOrderAnnotation records the order of the entries of a v1beta1 SopsSecret while it is stored as
+// v1alpha1, which keeps entries in maps. It is only set if the entries are not sorted by name.
+const entryOrderAnnotation = "craftypath.github.io/entry-order"
+
+// ConvertTo converts this SopsSecret to the hub version v1alpha1.
+func (src *SopsSecret) ConvertTo(dstRaw conversion.Hub) error {
+	dst := dstRaw.(*v1alpha1.SopsSecret)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	spec := &src.Spec
+	dst.Spec = v1alpha1.SopsSecretSpec{
+		Metadata:        convertObjectMetaTo(spec.Metadata),
+		AdoptionPolicy:  v1alpha1.AdoptionPolicy(spec.AdoptionPolicy),
+		DeletionPolicy:  v1alpha1.DeletionPolicy(spec.DeletionPolicy),
+		Template:        spec.Template,
+		Manifest:        spec.Manifest,
+		Type:            spec.Type,
+		FailurePolicy:   v1alpha1.FailurePolicy(spec.FailurePolicy),
+		Immutable:       spec.Immutable,
+		RecreatePolicy:  v1alpha1.RecreatePolicy(spec.RecreatePolicy),
+		RefreshInterval: spec.RefreshInterval,
+		DriftPolicy:     v1alpha1.DriftPolicy(spec.DriftPolicy),
+	}
+	if spec.Target != nil {
+		dst.Spec.Target = &v1alpha1.SopsSecretTarget{Name: spec.Target.Name, Namespace: spec.Target.Namespace}
+	}
+	for _, target := range spec.Targets {
+		dst.Spec.Targets = append(dst.Spec.Targets, v1alpha1.SopsSecretTargetSecret{
+			Name:      target.Name,
+			Namespace: target.Namespace,
+			Type:      target.Type,
+			Metadata:  convertObjectMetaTo(target.Metadata),
+			Keys:      convertKeyMappingsTo(target.Keys),
+		})
+	}
+	if spec.Decryption != nil {
+		dst.Spec.Decryption = &v1alpha1.SopsSecretDecryption{KeyRef: spec.Decryption.KeyRef}
+	}
+	for _, target := range spec.RolloutTargets {
+		dst.Spec.RolloutTargets = append(dst.Spec.RolloutTargets, v1alpha1.SopsSecretRolloutTarget{
+			Kind:     v1alpha1.WorkloadKind(target.Kind),
+			Name:     target.Name,
+			Selector: target.Selector,
+		})
+	}
+
+	names := make([]string, 0, len(spec.Entries))
+	for _, entry := range spec.Entries {
+		// Entries are stored in separate fields of the hub, so an entry with no content or with multiple
+		// contents cannot be converted without losing data. The conversion webhook rejects such entries.
+		if err := validateEntryContent(entry); err != nil {
+			return err
+		}
+		names = append(names, entry.Name)
+		if entry.SourceRef != nil {
+			ref := v1alpha1.SopsSecretSourceRef{
+				Kind: v1alpha1.SourceKind(entry.SourceRef.Kind),
+				Name: entry.SourceRef.Name,
+				Key:  entry.SourceRef.Key,
+				Path: entry.SourceRef.Path,
+			}
+			if entry.Name != ref.GetEntryName() {
+				ref.EntryName = entry.Name
+			}
+			dst.Spec.SourceRefs = append(dst.Spec.SourceRefs, ref)
+		} else if entry.EncryptedBinary != nil {
+			if dst.Spec.Data == nil {
+				dst.Spec.Data = make(map[string][]byte)
+			}
+			dst.Spec.Data[entry.Name] = entry.EncryptedBinary
+		} else {
+			if dst.Spec.StringData == nil {
+				dst.Spec.StringData = make(map[string]string)
+			}
+			dst.Spec.StringData[entry.Name] = entry.Encrypted
+		}
+
+		if entry.Format == "" && entry.Key == "" && entry.Expand == nil {
+			continue
+		}
+		if dst.Spec.Options == nil {
+			dst.Spec.Options = make(map[string]v1alpha1.SopsSecretEntryOptions)
+		}
+		options := v1alpha1.SopsSecretEntryOptions{
+			Format: v1alpha1.EntryFormat(entry.Format),
+			Key:    entry.Key,
+		}
+		if entry.Expand != nil {
+			options.Expand = &v1alpha1.SopsSecretExpand{
+				Mode:   v1alpha1.ExpandMode(entry.Expand.Mode),
+				Prefix: entry.Expand.Prefix,
+			}
+		}
+		dst.Spec.Options[entry.Name] = options
+	}
+	if !sort.StringsAreSorted(names) {
+		if dst.Annotations == nil {
+			dst.Annotations = make(map[string]string)
+		}
+		dst.Annotations[entryOrderAnnotation] = strings.Join(names, ",")
+	} else {
+		delete(dst.Annotations, entryOrderAnnotation)
+	}
+
+	status := &src.Status
+	dst.Status = v1alpha1.SopsSecretStatus{
+		ObservedGeneration: status.ObservedGeneration,
+		LastUpdate:         status.LastUpdate,
+		DataHash:           status.DataHash,
+		Target:             status.Target,
+		LastRolloutTime:    status.LastRolloutTime,
+		RolloutDataHash:    status.RolloutDataHash,
+	}
+	dst.SetConditions(status.Conditions)
+	for _, key := range status.Keys {
+		dst.Status.Keys = append(dst.Status.Keys, v1alpha1.SopsSecretKeyStatus(key))
+	}
+	for _, target := range status.Targets {
+		dst.Status.Targets = append(dst.Status.Targets, v1alpha1.SopsSecretTargetStatus(target))
+	}
+	return nil
+}
+
+// ConvertFrom converts from the hub version v1alpha1 to this version.
+func (dst *SopsSecret) ConvertFrom(srcRaw conversion.Hub) error {
+	src := srcRaw.(*v1alpha1.SopsSecret)
+	dst.ObjectMeta = *src.ObjectMeta.DeepCopy()
+
+	spec := &src.Spec
+	dst.Spec = SopsSecretSpec{
+		Metadata:        convertObjectMetaFrom(spec.Metadata),
+		AdoptionPolicy:  AdoptionPolicy(spec.AdoptionPolicy),
+		DeletionPolicy:  DeletionPolicy(spec.DeletionPolicy),
+		Template:        spec.Template,
+		Manifest:        spec.Manifest,
+		Type:            spec.Type,
+		FailurePolicy:   FailurePolicy(spec.FailurePolicy),
+		Immutable:       spec.Immutable,
+		RecreatePolicy:  RecreatePolicy(spec.RecreatePolicy),
+		RefreshInterval: spec.RefreshInterval,
+		DriftPolicy:     DriftPolicy(spec.DriftPolicy),
+	}
+	if spec.Target != nil {
+		dst.Spec.Target = &SopsSecretTarget{Name: spec.Target.Name, Namespace: spec.Target.Namespace}
+	}
+	for _, target := range spec.Targets {
+		dst.Spec.Targets = append(dst.Spec.Targets, SopsSecretTargetSecret{
+			Name:      target.Name,
+			Namespace: target.Namespace,
+			Type:      target.Type,
+			Metadata:  convertObjectMetaFrom(target.Metadata),
+			Keys:      convertKeyMappingsFrom(target.Keys),
+		})
+	}
+	if spec.Decryption != nil {
+		dst.Spec.Decryption = &SopsSecretDecryption{KeyRef: spec.Decryption.KeyRef}
+	}
+	for _, target := range spec.RolloutTargets {
+		dst.Spec.RolloutTargets = append(dst.Spec.RolloutTargets, SopsSecretRolloutTarget{
+			Kind:     WorkloadKind(target.Kind),
+			Name:     target.Name,
+			Selector: target.Selector,
+		})
+	}
+
+	sourceRefs := make(map[string]v1alpha1.SopsSecretSourceRef, len(spec.SourceRefs))
+	for _, ref := range spec.SourceRefs {
+		sourceRefs[ref.GetEntryName()] = ref
+	}
+	for _, name := range entryOrder(src) {
+		entry := SopsSecretEntry{Name: name}
+		if ref, exists := sourceRefs[name]; exists {
+			entry.SourceRef = &SopsSecretEntrySourceRef{
+				Kind: SourceKind(ref.Kind),
+				Name: ref.Name,
+				Key:  ref.Key,
+				Path: ref.Path,
+			}
+		} else if value, exists := spec.Data[name]; exists {
+			entry.EncryptedBinary = value
+		} else {
+			entry.Encrypted = spec.StringData[name]
+		}
+		if options, exists := spec.Options[name]; exists {
+			entry.Format = EntryFormat(options.Format)
+			entry.Key = options.Key
+			if options.Expand != nil {
+				entry.Expand = &SopsSecretExpand{
+					Mode:   ExpandMode(options.Expand.Mode),
+					Prefix: options.Expand.Prefix,
+				}
+			}
+		}
+		dst.Spec.Entries = append(dst.Spec.Entries, entry)
+	}
+	if _, exists := dst.Annotations[entryOrderAnnotation]; exists {
+		delete(dst.Annotations, entryOrderAnnotation)
+		if len(dst.Annotations) == 0 {
+			dst.Annotations = nil
+		}
+	}
+
+	status := &src.Status
+	dst.Status = SopsSecretStatus{
+		ObservedGeneration: status.ObservedGeneration,
+		LastUpdate:         status.LastUpdate,
+		Conditions:         status.Conditions,
+		DataHash:           status.DataHash,
+		Target:             status.Target,
+		LastRolloutTime:    status.LastRolloutTime,
+		RolloutDataHash:    status.RolloutDataHash,
+	}
+	for _, key := range status.Keys {
+		dst.Status.Keys = append(dst.Status.Keys, SopsSecretKeyStatus(key))
+	}
+	for _, target := range status.Targets {
+		dst.Status.Targets = append(dst.Status.Targets, SopsSecretTargetStatus(target))
+	}
+	return nil
+}
+
+// validateEntryContent verifies that exactly one of Encrypted, EncryptedBinary and SourceRef is specified for the given entry.
+func validateEntryContent(entry SopsSecretEntry) error {
+	var specified []string
+	if entry.Encrypted != "" {
+		specified = append(specified, "encrypted")
+	}
+	if len(entry.EncryptedBinary) > 0 {
+		specified = append(specified, "encryptedBinary")
+	}
+	if entry.SourceRef != nil {
+		specified = append(specified, "sourceRef")
+	}
+	if len(specified) != 1 {
+		return fmt.Errorf("entry %q must specify exactly one of encrypted, encryptedBinary and sourceRef, got %d", entry.Name, len(specified))
+	}
+	return nil
+}
+
+// entryOrder returns the names of the entries of the given v1alpha1 SopsSecret in the order recorded
+// in its entry order annotation, followed by any other entries sorted by name.
+func entryOrder(sopsSecret *v1alpha1.SopsSecret) []string {
+	exists := func(name string) bool {
+		_, inStringData := sopsSecret.Spec.StringData[name]
+		_, inData := sopsSecret.Spec.Data[name]
+		inSourceRefs := false
+		for _, ref := range sopsSecret.Spec.SourceRefs {
+			inSourceRefs = inSourceRefs || ref.GetEntryName() == name
+		}
+		return inStringData || inData || inSourceRefs
+	}
+
+	var names []string
+	seen := make(map[string]bool)
+	if order := sopsSecret.Annotations[entryOrderAnnotation]; order != "" {
+		for _, name := range strings.Split(order, ",") {
+			if exists(name) && !seen[name] {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+	}
+
+	var remaining []string
+	for name := range sopsSecret.Spec.StringData {
+		if !seen[name] {
+			remaining = append(remaining, name)
+			seen[name] = true
+		}
+	}
+	for name := range sopsSecret.Spec.Data {
+		if !seen[name] {
+			remaining = append(remaining, name)
+			seen[name] = true
+		}
+	}
+	for _, ref := range sopsSecret.Spec.SourceRefs {
+		if name := ref.GetEntryName(); !seen[name] {
+			remaining = append(remaining, name)
+			seen[name] = true
+		}
+	}
+	sort.Strings(remaining)
+	return append(names, remaining...)
+}
+
+func convertObjectMetaTo(meta SopsSecretObjectMeta) v1alpha1.SopsSecretObjectMeta {
+	return v1alpha1.SopsSecretObjectMeta{Annotations: meta.Annotations, Labels: meta.Labels}
+}
+
+func convertObjectMetaFrom(meta v1alpha1.SopsSecretObjectMeta) SopsSecretObjectMeta {
+	return SopsSecretObjectMeta{Annotations: meta.Annotations, Labels: meta.Labels}
+}
+
+func convertKeyMappingsTo(mappings []SopsSecretKeyMapping) []v1alpha1.SopsSecretKeyMapping {
+	if mappings == nil {
+		return nil
+	}
+	converted := make([]v1alpha1.SopsSecretKeyMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		converted = append(converted, v1alpha1.SopsSecretKeyMapping(mapping))
+	}
+	return converted
+}
+
+func convertKeyMappingsFrom(mappings []v1alpha1.SopsSecretKeyMapping) []SopsSecretKeyMapping {
+	if mappings == nil {
+		return nil
+	}
+	converted := make([]SopsSecretKeyMapping, 0, len(mappings))
+	for _, mapping := range mappings {
+		converted = append(converted, SopsSecretKeyMapping(mapping))
+	}
+	return converted
+}
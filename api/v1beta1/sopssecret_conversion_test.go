@@ -0,0 +1,282 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+var objectMeta = metav1.ObjectMeta{
+	Name:        "test-secret",
+	Namespace:   "test-namespace",
+	Annotations: map[string]string{"foo": "bar"},
+}
+
+var status = SopsSecretStatus{
+	ObservedGeneration: 2,
+	Conditions: []metav1.Condition{
+		{Type: ConditionTypeReady, Status: metav1.ConditionTrue, Reason: "Reconciled"},
+	},
+	Keys: []SopsSecretKeyStatus{
+		{Name: "db.yaml", Format: "yaml", Size: 12, Hash: "abc"},
+	},
+	DataHash:        "def",
+	LastRolloutTime: &metav1.Time{Time: time.Date(2026, 10, 17, 0, 0, 0, 0, time.UTC)},
+	RolloutDataHash: "def",
+	Targets: []SopsSecretTargetStatus{
+		{Name: "app", Namespace: "test-namespace", Status: "Created"},
+	},
+}
+
+func TestConversion_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		spec SopsSecretSpec
+	}{
+		{
+			name: "entries",
+			spec: SopsSecretSpec{
+				Entries: []SopsSecretEntry{
+					{Name: "cert.der", EncryptedBinary: []byte("binary")},
+					{Name: "db", Encrypted: "encrypted", Format: EntryFormatYAML, Expand: &SopsSecretExpand{Mode: ExpandModeFlatten, Prefix: "DB_"}},
+					{Name: "test.yaml", Encrypted: "encrypted", Key: "config.yaml"},
+				},
+			},
+		},
+		{
+			name: "unsorted entries",
+			spec: SopsSecretSpec{
+				Entries: []SopsSecretEntry{
+					{Name: "z.yaml", Encrypted: "encrypted"},
+					{Name: "a.yaml", Encrypted: "encrypted"},
+					{Name: "m.bin", EncryptedBinary: []byte("binary")},
+				},
+			},
+		},
+		{
+			name: "source refs",
+			spec: SopsSecretSpec{
+				Entries: []SopsSecretEntry{
+					{Name: "test.yaml", Encrypted: "encrypted"},
+					{Name: "db.yaml", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindConfigMap, Name: "shared", Key: "db.yaml"}},
+					{Name: "app.env", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindSecret, Name: "shared", Key: "env"}, Format: EntryFormatDotenv},
+					{Name: "prod.yaml", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindGitRepository, Name: "config", Path: "./secrets/prod.yaml"}},
+					{Name: "ca.pem", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindOCIRepository, Name: "certs", Path: "ca.crt"}},
+				},
+			},
+		},
+		{
+			name: "manifest",
+			spec: SopsSecretSpec{
+				Manifest: "encrypted",
+				Type:     corev1.SecretTypeTLS,
+			},
+		},
+		{
+			name: "policies and targets",
+			spec: SopsSecretSpec{
+				Metadata:        SopsSecretObjectMeta{Labels: map[string]string{"app": "test"}},
+				AdoptionPolicy:  AdoptionPolicyAdoptIfLabeled,
+				DeletionPolicy:  DeletionPolicyOrphan,
+				FailurePolicy:   FailurePolicyBestEffort,
+				Immutable:       true,
+				RecreatePolicy:  RecreatePolicyAllow,
+				Decryption:      &SopsSecretDecryption{KeyRef: &corev1.LocalObjectReference{Name: "sops-keys"}},
+				Entries:         []SopsSecretEntry{{Name: "db.yaml", Encrypted: "encrypted"}},
+				Template:        map[string]string{"url": `{{ index .Data "db.yaml" "host" }}`},
+				RefreshInterval: &metav1.Duration{Duration: time.Hour},
+				DriftPolicy:     DriftPolicyReport,
+				RolloutTargets: []SopsSecretRolloutTarget{
+					{Kind: WorkloadKindDeployment, Name: "app"},
+					{Kind: WorkloadKindStatefulSet, Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "db"}}},
+				},
+				Targets: []SopsSecretTargetSecret{
+					{
+						Name:      "app",
+						Namespace: "other",
+						Type:      corev1.SecretTypeBasicAuth,
+						Metadata:  SopsSecretObjectMeta{Annotations: map[string]string{"foo": "bar"}},
+						Keys:      []SopsSecretKeyMapping{{Key: "url", Name: "DATABASE_URL"}},
+					},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &SopsSecret{
+				ObjectMeta: *objectMeta.DeepCopy(),
+				Spec:       tt.spec,
+				Status:     status,
+			}
+
+			hub := &v1alpha1.SopsSecret{}
+			require.NoError(t, sopsSecret.ConvertTo(hub))
+			converted := &SopsSecret{}
+			require.NoError(t, converted.ConvertFrom(hub))
+			assert.Equal(t, sopsSecret, converted)
+
+			// converting the hub back and forth must not change it either
+			convertedHub := &v1alpha1.SopsSecret{}
+			require.NoError(t, converted.ConvertTo(convertedHub))
+			assert.Equal(t, hub, convertedHub)
+		})
+	}
+}
+
+func TestConversion_RoundTripEntryContent(t *testing.T) {
+	tests := []struct {
+		name    string
+		entry   SopsSecretEntry
+		wantErr string
+	}{
+		{name: "encrypted", entry: SopsSecretEntry{Name: "db.yaml", Encrypted: "encrypted"}},
+		{name: "encrypted binary", entry: SopsSecretEntry{Name: "db.yaml", EncryptedBinary: []byte("binary")}},
+		{name: "source ref", entry: SopsSecretEntry{Name: "db.yaml", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindSecret, Name: "shared", Key: "db.yaml"}}},
+		{
+			name:    "encrypted and encrypted binary",
+			entry:   SopsSecretEntry{Name: "db.yaml", Encrypted: "encrypted", EncryptedBinary: []byte("binary")},
+			wantErr: `entry "db.yaml" must specify exactly one of encrypted, encryptedBinary and sourceRef, got 2`,
+		},
+		{
+			name:    "encrypted and source ref",
+			entry:   SopsSecretEntry{Name: "db.yaml", Encrypted: "encrypted", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindSecret, Name: "shared", Key: "db.yaml"}},
+			wantErr: `entry "db.yaml" must specify exactly one of encrypted, encryptedBinary and sourceRef, got 2`,
+		},
+		{
+			name:    "none",
+			entry:   SopsSecretEntry{Name: "db.yaml", Format: EntryFormatYAML},
+			wantErr: `entry "db.yaml" must specify exactly one of encrypted, encryptedBinary and sourceRef, got 0`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &SopsSecret{
+				ObjectMeta: *objectMeta.DeepCopy(),
+				Spec:       SopsSecretSpec{Entries: []SopsSecretEntry{tt.entry}},
+			}
+
+			hub := &v1alpha1.SopsSecret{}
+			err := sopsSecret.ConvertTo(hub)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			converted := &SopsSecret{}
+			require.NoError(t, converted.ConvertFrom(hub))
+			assert.Equal(t, sopsSecret, converted)
+		})
+	}
+}
+
+func TestConversion_ConvertTo(t *testing.T) {
+	sopsSecret := &SopsSecret{
+		ObjectMeta: *objectMeta.DeepCopy(),
+		Spec: SopsSecretSpec{
+			Entries: []SopsSecretEntry{
+				{Name: "test.yaml", Encrypted: "encrypted", Key: "config.yaml"},
+				{Name: "cert.der", EncryptedBinary: []byte("binary")},
+			},
+		},
+	}
+
+	hub := &v1alpha1.SopsSecret{}
+	require.NoError(t, sopsSecret.ConvertTo(hub))
+	assert.Equal(t, map[string]string{"test.yaml": "encrypted"}, hub.Spec.StringData)
+	assert.Equal(t, map[string][]byte{"cert.der": []byte("binary")}, hub.Spec.Data)
+	assert.Equal(t, map[string]v1alpha1.SopsSecretEntryOptions{"test.yaml": {Key: "config.yaml"}}, hub.Spec.Options)
+	assert.Equal(t, map[string]string{"foo": "bar", entryOrderAnnotation: "test.yaml,cert.der"}, hub.Annotations)
+	// the source must not be modified
+	assert.Equal(t, map[string]string{"foo": "bar"}, sopsSecret.Annotations)
+}
+
+func TestConversion_ConvertToDeprecatedStatus(t *testing.T) {
+	sopsSecret := &SopsSecret{
+		ObjectMeta: *objectMeta.DeepCopy(),
+		Status: SopsSecretStatus{
+			Conditions: []metav1.Condition{
+				{Type: ConditionTypeReady, Status: metav1.ConditionFalse, Reason: "DecryptionFailed", Message: "kms unavailable"},
+			},
+		},
+	}
+
+	// the deprecated v1alpha1 status fields are derived from the Ready condition
+	hub := &v1alpha1.SopsSecret{}
+	require.NoError(t, sopsSecret.ConvertTo(hub))
+	assert.Equal(t, "Failure", hub.Status.Status)
+	assert.Equal(t, "kms unavailable", hub.Status.Reason)
+}
+
+func TestConversion_ConvertToSourceRefs(t *testing.T) {
+	sopsSecret := &SopsSecret{
+		ObjectMeta: *objectMeta.DeepCopy(),
+		Spec: SopsSecretSpec{
+			Entries: []SopsSecretEntry{
+				{Name: "app.env", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindSecret, Name: "shared", Key: "env"}, Key: "APP_ENV"},
+				{Name: "db.yaml", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindConfigMap, Name: "shared", Key: "db.yaml"}},
+				{Name: "prod.yaml", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindGitRepository, Name: "config", Path: "secrets/prod.yaml"}},
+				{Name: "bucket.yaml", SourceRef: &SopsSecretEntrySourceRef{Kind: SourceKindBucket, Name: "config", Path: "prod.yaml"}},
+			},
+		},
+	}
+
+	hub := &v1alpha1.SopsSecret{}
+	require.NoError(t, sopsSecret.ConvertTo(hub))
+	assert.Equal(t, []v1alpha1.SopsSecretSourceRef{
+		{Kind: v1alpha1.SourceKindSecret, Name: "shared", Key: "env", EntryName: "app.env"},
+		{Kind: v1alpha1.SourceKindConfigMap, Name: "shared", Key: "db.yaml"},
+		{Kind: v1alpha1.SourceKindGitRepository, Name: "config", Path: "secrets/prod.yaml"},
+		{Kind: v1alpha1.SourceKindBucket, Name: "config", Path: "prod.yaml", EntryName: "bucket.yaml"},
+	}, hub.Spec.SourceRefs)
+	assert.Equal(t, map[string]v1alpha1.SopsSecretEntryOptions{"app.env": {Key: "APP_ENV"}}, hub.Spec.Options)
+	assert.Empty(t, hub.Spec.StringData)
+	assert.Empty(t, hub.Spec.Data)
+}
+
+func TestConversion_ConvertFrom(t *testing.T) {
+	hub := &v1alpha1.SopsSecret{
+		ObjectMeta: *objectMeta.DeepCopy(),
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData: map[string]string{"test.yaml": "encrypted", "db.yaml": "encrypted"},
+			Data:       map[string][]byte{"cert.der": []byte("binary")},
+			Options: map[string]v1alpha1.SopsSecretEntryOptions{
+				"db.yaml": {Expand: &v1alpha1.SopsSecretExpand{Mode: v1alpha1.ExpandModeTopLevel}},
+			},
+		},
+	}
+
+	sopsSecret := &SopsSecret{}
+	require.NoError(t, sopsSecret.ConvertFrom(hub))
+	assert.Equal(t, []SopsSecretEntry{
+		{Name: "cert.der", EncryptedBinary: []byte("binary")},
+		{Name: "db.yaml", Encrypted: "encrypted", Expand: &SopsSecretExpand{Mode: ExpandModeTopLevel}},
+		{Name: "test.yaml", Encrypted: "encrypted"},
+	}, sopsSecret.Spec.Entries)
+
+	converted := &v1alpha1.SopsSecret{}
+	require.NoError(t, sopsSecret.ConvertTo(converted))
+	assert.Equal(t, hub, converted)
+}
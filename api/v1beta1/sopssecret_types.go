@@ -0,0 +1,491 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1beta1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// SopsSecretObjectMeta defines metadata for generated Secrets.
+type SopsSecretObjectMeta struct {
+	// Annotations allows adding annotations to generated Secrets.
+	// +optional
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// Labels allows adding labels to generated Secrets.
+	// +optional
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// SopsSecretDecryption defines how the data of a SopsSecret is decrypted.
+type SopsSecretDecryption struct {
+	// KeyRef references a Secret in the same namespace holding the private keys used for decryption
+	// instead of the keys available to the operator. Keys ending with '.agekey' are read as age identities,
+	// keys ending with '.asc' as armored PGP private keys.
+	// +optional
+	KeyRef *corev1.LocalObjectReference `json:"keyRef,omitempty"`
+}
+
+// ExpandMode defines how a decrypted document is split into Secret keys.
+// +kubebuilder:validation:Enum=TopLevel;Flatten
+type ExpandMode string
+
+const (
+	// ExpandModeTopLevel creates one key per top-level field. Nested values are rejected.
+	ExpandModeTopLevel ExpandMode = "TopLevel"
+	// ExpandModeFlatten creates one key per leaf value, named by its dotted path.
+	ExpandModeFlatten ExpandMode = "Flatten"
+)
+
+// SopsSecretExpand defines how a decrypted yaml, json, dotenv or ini document is split into Secret keys.
+type SopsSecretExpand struct {
+	// Mode specifies how the document is split. TopLevel creates one key per top-level field,
+	// Flatten creates one key per leaf value named by its dotted path, e.g. 'database.password'.
+	// Lists cannot be represented in either mode.
+	// +kubebuilder:default=TopLevel
+	// +optional
+	Mode ExpandMode `json:"mode,omitempty"`
+
+	// Prefix is prepended to the names of the generated keys.
+	// +optional
+	Prefix string `json:"prefix,omitempty"`
+}
+
+// EntryFormat is the format of a Sops-encrypted entry.
+// +kubebuilder:validation:Enum=yaml;json;dotenv;ini;binary
+type EntryFormat string
+
+const (
+	EntryFormatYAML   EntryFormat = "yaml"
+	EntryFormatJSON   EntryFormat = "json"
+	EntryFormatDotenv EntryFormat = "dotenv"
+	EntryFormatINI    EntryFormat = "ini"
+	EntryFormatBinary EntryFormat = "binary"
+)
+
+// SopsSecretEntry defines a Sops-encrypted entry of a SopsSecret.
+type SopsSecretEntry struct {
+	// Name is the name of the entry. It is the entry's key in generated Secrets unless overridden
+	// with Key, and its extension determines the entry's format unless overridden with Format.
+	// +kubebuilder:validation:Pattern=`^[-._a-zA-Z0-9]+$`
+	Name string `json:"name"`
+
+	// Encrypted is the Sops-encrypted content of the entry in string form.
+	// Exactly one of Encrypted, EncryptedBinary and SourceRef must be specified; SopsSecrets with
+	// entries specifying none or several of them are rejected by the conversion webhook.
+	// +optional
+	Encrypted string `json:"encrypted,omitempty"`
+
+	// EncryptedBinary is the Sops-encrypted content of the entry in base64-encoded form,
+	// e.g. for encrypted binary files.
+	// +optional
+	EncryptedBinary []byte `json:"encryptedBinary,omitempty"`
+
+	// SourceRef references the Sops-encrypted content of the entry stored in a ConfigMap, a Secret
+	// or the artifact of a Flux source, e.g. for large files or content shared by multiple SopsSecrets.
+	// +optional
+	SourceRef *SopsSecretEntrySourceRef `json:"sourceRef,omitempty"`
+
+	// Format overrides the format of the entry, which is determined by the extension of its name by default.
+	// +optional
+	Format EntryFormat `json:"format,omitempty"`
+
+	// Key is the key of the decrypted entry in generated Secrets. Defaults to the entry's name.
+	// Key cannot be combined with Expand.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Expand splits the decrypted document into one Secret key per field instead of
+	// storing it verbatim under the entry's key.
+	// +optional
+	Expand *SopsSecretExpand `json:"expand,omitempty"`
+}
+
+// SourceKind is the kind of an object holding Sops-encrypted content.
+// +kubebuilder:validation:Enum=ConfigMap;Secret;GitRepository;Bucket;OCIRepository
+type SourceKind string
+
+const (
+	SourceKindConfigMap     SourceKind = "ConfigMap"
+	SourceKindSecret        SourceKind = "Secret"
+	SourceKindGitRepository SourceKind = "GitRepository"
+	SourceKindBucket        SourceKind = "Bucket"
+	SourceKindOCIRepository SourceKind = "OCIRepository"
+)
+
+// SopsSecretEntrySourceRef references Sops-encrypted content stored under a key of a ConfigMap or Secret,
+// or in a file of the artifact of a Flux source.
+type SopsSecretEntrySourceRef struct {
+	// Kind is the kind of the referenced object.
+	Kind SourceKind `json:"kind"`
+
+	// Name is the name of the referenced object in the namespace of the SopsSecret.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Key is the key of the encrypted content in the data of the referenced ConfigMap or Secret.
+	// +optional
+	Key string `json:"key,omitempty"`
+
+	// Path is the path of the encrypted file in the artifact of the referenced GitRepository,
+	// Bucket or OCIRepository.
+	// +optional
+	Path string `json:"path,omitempty"`
+}
+
+// AdoptionPolicy defines how Secrets that already exist and are not owned by the SopsSecret are handled.
+// +kubebuilder:validation:Enum=Fail;Adopt;AdoptIfLabeled
+type AdoptionPolicy string
+
+const (
+	// AdoptionPolicyFail refuses to take over existing Secrets.
+	AdoptionPolicyFail AdoptionPolicy = "Fail"
+	// AdoptionPolicyAdopt takes over existing Secrets.
+	AdoptionPolicyAdopt AdoptionPolicy = "Adopt"
+	// AdoptionPolicyAdoptIfLabeled takes over existing Secrets labeled 'app.kubernetes.io/managed-by: sops-operator'.
+	AdoptionPolicyAdoptIfLabeled AdoptionPolicy = "AdoptIfLabeled"
+)
+
+// DeletionPolicy defines what happens to generated Secrets when a SopsSecret is deleted.
+// +kubebuilder:validation:Enum=Delete;Orphan
+type DeletionPolicy string
+
+const (
+	// DeletionPolicyDelete deletes generated Secrets together with the SopsSecret.
+	DeletionPolicyDelete DeletionPolicy = "Delete"
+	// DeletionPolicyOrphan keeps generated Secrets, removing the SopsSecret's ownership.
+	DeletionPolicyOrphan DeletionPolicy = "Orphan"
+)
+
+// RecreatePolicy defines whether generated Secrets may be recreated in order to change immutable fields.
+// +kubebuilder:validation:Enum=Allow;Never
+type RecreatePolicy string
+
+const (
+	// RecreatePolicyAllow deletes and recreates generated Secrets whose immutable fields change.
+	RecreatePolicyAllow RecreatePolicy = "Allow"
+	// RecreatePolicyNever fails reconciliation if immutable fields of generated Secrets change.
+	RecreatePolicyNever RecreatePolicy = "Never"
+)
+
+// DriftPolicy defines how changes to generated Secrets made by others than the operator are handled.
+// +kubebuilder:validation:Enum=Correct;Report;Ignore
+type DriftPolicy string
+
+const (
+	// DriftPolicyCorrect overwrites drifted Secrets and records an event naming the changes.
+	DriftPolicyCorrect DriftPolicy = "Correct"
+	// DriftPolicyReport keeps drifted Secrets as they are and sets the Drifted condition.
+	DriftPolicyReport DriftPolicy = "Report"
+	// DriftPolicyIgnore keeps drifted Secrets as they are without reporting them.
+	DriftPolicyIgnore DriftPolicy = "Ignore"
+)
+
+// FailurePolicy defines how entries that cannot be decrypted are handled.
+// +kubebuilder:validation:Enum=AllOrNothing;BestEffort
+type FailurePolicy string
+
+const (
+	// FailurePolicyAllOrNothing does not update generated Secrets if any entry cannot be decrypted.
+	FailurePolicyAllOrNothing FailurePolicy = "AllOrNothing"
+	// FailurePolicyBestEffort updates generated Secrets with all entries that can be decrypted,
+	// keeping the last good values of entries that cannot be decrypted.
+	FailurePolicyBestEffort FailurePolicy = "BestEffort"
+)
+
+// SopsSecretTarget defines the Secret generated from a SopsSecret.
+type SopsSecretTarget struct {
+	// Name is the name of the generated Secret. Defaults to the name of the SopsSecret.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Namespace is the namespace of the generated Secret. Defaults to the namespace of the SopsSecret.
+	// Other namespaces must allow the namespace of the SopsSecret with the annotation
+	// craftypath.github.io/allowed-source-namespaces.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+}
+
+// SopsSecretTargetSecret specifies one of multiple Secrets generated from a SopsSecret.
+type SopsSecretTargetSecret struct {
+	// Name is the name of the generated Secret.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Namespace is the namespace of the generated Secret. Defaults to the namespace of the SopsSecret.
+	// Other namespaces must allow the namespace of the SopsSecret with the annotation
+	// craftypath.github.io/allowed-source-namespaces.
+	// +optional
+	Namespace string `json:"namespace,omitempty"`
+
+	// Type specifies the type of the generated Secret. Defaults to the type of the SopsSecret.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+
+	// Metadata allows adding labels and annotations to the generated Secret.
+	// They are merged with those of the SopsSecret, taking precedence.
+	// +optional
+	Metadata SopsSecretObjectMeta `json:"metadata,omitempty"`
+
+	// Keys selects the keys of the generated Secret from the decrypted, expanded and templated entries.
+	// Defaults to all entries.
+	// +optional
+	Keys []SopsSecretKeyMapping `json:"keys,omitempty"`
+}
+
+// SopsSecretKeyMapping selects an entry for a generated Secret.
+type SopsSecretKeyMapping struct {
+	// Key is the key of a decrypted, expanded or templated entry.
+	// +kubebuilder:validation:MinLength=1
+	Key string `json:"key"`
+
+	// Name is the key of the entry in the generated Secret. Defaults to Key.
+	// +optional
+	Name string `json:"name,omitempty"`
+}
+
+// WorkloadKind is the kind of workloads whose pods can be restarted.
+// +kubebuilder:validation:Enum=Deployment;StatefulSet;DaemonSet
+type WorkloadKind string
+
+const (
+	WorkloadKindDeployment  WorkloadKind = "Deployment"
+	WorkloadKindStatefulSet WorkloadKind = "StatefulSet"
+	WorkloadKindDaemonSet   WorkloadKind = "DaemonSet"
+)
+
+// SopsSecretRolloutTarget selects workloads whose pods are restarted when the data of generated Secrets changes.
+// Workloads are selected by name or label selector in the namespace of the SopsSecret. If neither is specified,
+// all workloads of the kind that reference a generated Secret in its namespace are selected.
+type SopsSecretRolloutTarget struct {
+	// Kind is the kind of the workloads.
+	Kind WorkloadKind `json:"kind"`
+
+	// Name selects the workload with the given name.
+	// +optional
+	Name string `json:"name,omitempty"`
+
+	// Selector selects the workloads matching the given label selector. Name and Selector cannot be combined.
+	// +optional
+	Selector *metav1.LabelSelector `json:"selector,omitempty"`
+}
+
+// SopsSecretSpec defines the desired state of SopsSecret.
+type SopsSecretSpec struct {
+	// Metadata allows adding labels and annotations to generated Secrets.
+	// +optional
+	Metadata SopsSecretObjectMeta `json:"metadata,omitempty"`
+
+	// Target allows overriding the name and namespace of the generated Secret.
+	// When the target changes, the previously generated Secret is deleted.
+	// +optional
+	Target *SopsSecretTarget `json:"target,omitempty"`
+
+	// Targets allows generating multiple Secrets from the decrypted entries, each with its own
+	// name, type, metadata and keys. Secrets removed from the list are deleted.
+	// Targets cannot be combined with Target.
+	// +optional
+	Targets []SopsSecretTargetSecret `json:"targets,omitempty"`
+
+	// AdoptionPolicy specifies how generated Secrets that already exist and are not owned by the SopsSecret
+	// are handled. Adopted Secrets are taken over and their previous owners are recorded in the annotation
+	// 'craftypath.github.io/previous-owner'. Secrets controlled by another controller are never adopted.
+	// Secrets in other namespaces are only adopted if their namespace lists the namespace of the SopsSecret
+	// in the annotation 'craftypath.github.io/allowed-adoption-namespaces'.
+	// +kubebuilder:default=Fail
+	// +optional
+	AdoptionPolicy AdoptionPolicy `json:"adoptionPolicy,omitempty"`
+
+	// DeletionPolicy specifies what happens to generated Secrets when the SopsSecret is deleted.
+	// Orphaned Secrets are kept without owner reference and ownership labels.
+	// +kubebuilder:default=Delete
+	// +optional
+	DeletionPolicy DeletionPolicy `json:"deletionPolicy,omitempty"`
+
+	// Entries allows specifying Sops-encrypted secret data. Entry names must be unique.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Entries []SopsSecretEntry `json:"entries,omitempty"`
+
+	// Template allows specifying Secret keys whose values are rendered from Go templates after decryption.
+	// Templates are rendered with '.Data', holding the decrypted entries parsed according to their format,
+	// and '.Raw', holding the decrypted entries verbatim, e.g. '{{ index .Data "db.yaml" "password" }}'.
+	// Available functions are b64enc, b64dec, sha256sum, bcrypt, toJson, toYaml, indent, nindent and quote.
+	// +optional
+	Template map[string]string `json:"template,omitempty"`
+
+	// Manifest allows specifying a complete Sops-encrypted Secret manifest in YAML or JSON format,
+	// e.g. a file encrypted with 'sops --encrypt --encrypted-regex "^(data|stringData)$" secret.yaml'.
+	// Its data, stringData, type, labels and annotations are used for the generated Secret, with
+	// Metadata and Type taking precedence. Manifest cannot be combined with Entries.
+	// +optional
+	Manifest string `json:"manifest,omitempty"`
+
+	// Type specifies the type of the secret.
+	// +optional
+	Type corev1.SecretType `json:"type,omitempty"`
+
+	// FailurePolicy specifies how entries that cannot be decrypted are handled.
+	// With BestEffort, generated Secrets are updated with all other entries, the last good values of
+	// failing entries are kept and the Degraded condition lists the failing entries.
+	// +kubebuilder:default=AllOrNothing
+	// +optional
+	FailurePolicy FailurePolicy `json:"failurePolicy,omitempty"`
+
+	// Immutable specifies that the data of generated Secrets cannot be updated.
+	// Changing the data of immutable Secrets requires recreating them, see RecreatePolicy.
+	// +optional
+	Immutable bool `json:"immutable,omitempty"`
+
+	// RecreatePolicy specifies whether generated Secrets are deleted and recreated if fields that cannot be
+	// updated change, i.e. the type or the data of immutable Secrets.
+	// +kubebuilder:default=Never
+	// +optional
+	RecreatePolicy RecreatePolicy `json:"recreatePolicy,omitempty"`
+
+	// Decryption allows specifying the keys used to decrypt the data.
+	// +optional
+	Decryption *SopsSecretDecryption `json:"decryption,omitempty"`
+
+	// RolloutTargets selects workloads whose pods are restarted when the data of generated Secrets changes,
+	// by setting the annotation 'checksum.craftypath.github.io/<name of the SopsSecret>' on their pod templates.
+	// +optional
+	RolloutTargets []SopsSecretRolloutTarget `json:"rolloutTargets,omitempty"`
+
+	// RefreshInterval is the interval at which the data is decrypted again after successful reconciliations,
+	// proving that the keys are still usable. Defaults to the operator's default refresh interval.
+	// A zero interval disables periodic refreshes.
+	// +optional
+	RefreshInterval *metav1.Duration `json:"refreshInterval,omitempty"`
+
+	// DriftPolicy specifies how changes to the data or labels of generated Secrets that were not made by the
+	// operator are handled. Drifted Secrets are always updated if the generated data changes.
+	// +kubebuilder:default=Correct
+	// +optional
+	DriftPolicy DriftPolicy `json:"driftPolicy,omitempty"`
+}
+
+// Condition types of SopsSecrets.
+const (
+	// ConditionTypeReady indicates that the SopsSecret has been decrypted and all generated Secrets are synced.
+	ConditionTypeReady = "Ready"
+	// ConditionTypeDecrypted indicates that the SopsSecret's data has been decrypted.
+	ConditionTypeDecrypted = "Decrypted"
+	// ConditionTypeSecretSynced indicates that the generated Secrets match the decrypted data.
+	ConditionTypeSecretSynced = "SecretSynced"
+	// ConditionTypeDegraded indicates that generated Secrets contain last good values of entries that
+	// cannot be decrypted, see FailurePolicy.
+	ConditionTypeDegraded = "Degraded"
+	// ConditionTypeDrifted indicates that generated Secrets were changed by others than the operator
+	// and are kept as they are, see DriftPolicy.
+	ConditionTypeDrifted = "Drifted"
+)
+
+// SopsSecretStatus defines the observed state of SopsSecret.
+type SopsSecretStatus struct {
+	// ObservedGeneration is the generation of the SopsSecret that was last processed.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// LastUpdate is the time the status was last updated.
+	// +optional
+	LastUpdate metav1.Time `json:"lastUpdate,omitempty"`
+	// Conditions represent the latest observations of the SopsSecret's state.
+	// Known condition types are Ready, Decrypted, SecretSynced, Degraded and Drifted.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Keys reports the status of each entry, or of the Manifest.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Keys []SopsSecretKeyStatus `json:"keys,omitempty"`
+	// DataHash is an HMAC-SHA256 of the data of the generated Secrets keyed with the operator's hash key.
+	// +optional
+	DataHash string `json:"dataHash,omitempty"`
+	// Target is the Secret currently generated from the SopsSecret.
+	Target *corev1.SecretReference `json:"target,omitempty"`
+	// Targets reports the status of the Secrets generated from the SopsSecret's targets.
+	// +optional
+	Targets []SopsSecretTargetStatus `json:"targets,omitempty"`
+	// LastRolloutTime is the time pods of the rollout targets were last restarted.
+	// +optional
+	LastRolloutTime *metav1.Time `json:"lastRolloutTime,omitempty"`
+	// RolloutDataHash is the data hash the pods of the rollout targets were last restarted for or,
+	// if they have not been restarted yet, first observed with.
+	// +optional
+	RolloutDataHash string `json:"rolloutDataHash,omitempty"`
+}
+
+// SopsSecretKeyStatus defines the observed state of an entry of a SopsSecret.
+type SopsSecretKeyStatus struct {
+	// Name is the key of the entry.
+	Name string `json:"name"`
+	// Format is the format of the entry determined from its key, i.e. yaml, json, dotenv, ini or binary.
+	Format string `json:"format,omitempty"`
+	// Size is the size of the decrypted entry in bytes.
+	Size int64 `json:"size"`
+	// Hash is an HMAC-SHA256 of the decrypted entry keyed with the operator's hash key.
+	// It allows detecting changes without exposing the value.
+	Hash string `json:"hash,omitempty"`
+	// LastChanged is the time the decrypted entry last changed.
+	LastChanged metav1.Time `json:"lastChanged,omitempty"`
+	// ExpandedKeys are the keys of the generated Secrets expanded from the entry, if it is expanded.
+	// They are kept with their previous values while the entry cannot be decrypted.
+	ExpandedKeys []string `json:"expandedKeys,omitempty"`
+	// Error is the error that occurred decrypting the entry, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// SopsSecretTargetStatus defines the observed state of a Secret generated from a SopsSecret's targets.
+type SopsSecretTargetStatus struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Status    string `json:"status,omitempty"`
+	// Message is a human-readable message describing the last failure.
+	Message string `json:"message,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// SopsSecret is the Schema for the sopssecrets API
+type SopsSecret struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   SopsSecretSpec   `json:"spec,omitempty"`
+	Status SopsSecretStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// SopsSecretList contains a list of SopsSecret
+type SopsSecretList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []SopsSecret `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&SopsSecret{}, &SopsSecretList{})
+}
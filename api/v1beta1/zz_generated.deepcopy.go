@@ -0,0 +1,406 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1beta1
+
+import (
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	runtime "k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecret) DeepCopyInto(out *SopsSecret) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecret.
+func (in *SopsSecret) DeepCopy() *SopsSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SopsSecret) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretDecryption) DeepCopyInto(out *SopsSecretDecryption) {
+	*out = *in
+	if in.KeyRef != nil {
+		in, out := &in.KeyRef, &out.KeyRef
+		*out = new(v1.LocalObjectReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretDecryption.
+func (in *SopsSecretDecryption) DeepCopy() *SopsSecretDecryption {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretDecryption)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretEntry) DeepCopyInto(out *SopsSecretEntry) {
+	*out = *in
+	if in.EncryptedBinary != nil {
+		in, out := &in.EncryptedBinary, &out.EncryptedBinary
+		*out = make([]byte, len(*in))
+		copy(*out, *in)
+	}
+	if in.SourceRef != nil {
+		in, out := &in.SourceRef, &out.SourceRef
+		*out = new(SopsSecretEntrySourceRef)
+		**out = **in
+	}
+	if in.Expand != nil {
+		in, out := &in.Expand, &out.Expand
+		*out = new(SopsSecretExpand)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretEntry.
+func (in *SopsSecretEntry) DeepCopy() *SopsSecretEntry {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretEntry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretEntrySourceRef) DeepCopyInto(out *SopsSecretEntrySourceRef) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretEntrySourceRef.
+func (in *SopsSecretEntrySourceRef) DeepCopy() *SopsSecretEntrySourceRef {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretEntrySourceRef)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretExpand) DeepCopyInto(out *SopsSecretExpand) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretExpand.
+func (in *SopsSecretExpand) DeepCopy() *SopsSecretExpand {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretExpand)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretKeyMapping) DeepCopyInto(out *SopsSecretKeyMapping) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretKeyMapping.
+func (in *SopsSecretKeyMapping) DeepCopy() *SopsSecretKeyMapping {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretKeyMapping)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretKeyStatus) DeepCopyInto(out *SopsSecretKeyStatus) {
+	*out = *in
+	in.LastChanged.DeepCopyInto(&out.LastChanged)
+	if in.ExpandedKeys != nil {
+		in, out := &in.ExpandedKeys, &out.ExpandedKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretKeyStatus.
+func (in *SopsSecretKeyStatus) DeepCopy() *SopsSecretKeyStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretKeyStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretList) DeepCopyInto(out *SopsSecretList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]SopsSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretList.
+func (in *SopsSecretList) DeepCopy() *SopsSecretList {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *SopsSecretList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretObjectMeta) DeepCopyInto(out *SopsSecretObjectMeta) {
+	*out = *in
+	if in.Annotations != nil {
+		in, out := &in.Annotations, &out.Annotations
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Labels != nil {
+		in, out := &in.Labels, &out.Labels
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretObjectMeta.
+func (in *SopsSecretObjectMeta) DeepCopy() *SopsSecretObjectMeta {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretObjectMeta)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretRolloutTarget) DeepCopyInto(out *SopsSecretRolloutTarget) {
+	*out = *in
+	if in.Selector != nil {
+		in, out := &in.Selector, &out.Selector
+		*out = new(metav1.LabelSelector)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretRolloutTarget.
+func (in *SopsSecretRolloutTarget) DeepCopy() *SopsSecretRolloutTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretRolloutTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretSpec) DeepCopyInto(out *SopsSecretSpec) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(SopsSecretTarget)
+		**out = **in
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]SopsSecretTargetSecret, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Entries != nil {
+		in, out := &in.Entries, &out.Entries
+		*out = make([]SopsSecretEntry, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Template != nil {
+		in, out := &in.Template, &out.Template
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+	if in.Decryption != nil {
+		in, out := &in.Decryption, &out.Decryption
+		*out = new(SopsSecretDecryption)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.RolloutTargets != nil {
+		in, out := &in.RolloutTargets, &out.RolloutTargets
+		*out = make([]SopsSecretRolloutTarget, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.RefreshInterval != nil {
+		in, out := &in.RefreshInterval, &out.RefreshInterval
+		*out = new(metav1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretSpec.
+func (in *SopsSecretSpec) DeepCopy() *SopsSecretSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretStatus) DeepCopyInto(out *SopsSecretStatus) {
+	*out = *in
+	in.LastUpdate.DeepCopyInto(&out.LastUpdate)
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]metav1.Condition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]SopsSecretKeyStatus, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Target != nil {
+		in, out := &in.Target, &out.Target
+		*out = new(v1.SecretReference)
+		**out = **in
+	}
+	if in.Targets != nil {
+		in, out := &in.Targets, &out.Targets
+		*out = make([]SopsSecretTargetStatus, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastRolloutTime != nil {
+		in, out := &in.LastRolloutTime, &out.LastRolloutTime
+		*out = (*in).DeepCopy()
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretStatus.
+func (in *SopsSecretStatus) DeepCopy() *SopsSecretStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretTarget) DeepCopyInto(out *SopsSecretTarget) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretTarget.
+func (in *SopsSecretTarget) DeepCopy() *SopsSecretTarget {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretTarget)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretTargetSecret) DeepCopyInto(out *SopsSecretTargetSecret) {
+	*out = *in
+	in.Metadata.DeepCopyInto(&out.Metadata)
+	if in.Keys != nil {
+		in, out := &in.Keys, &out.Keys
+		*out = make([]SopsSecretKeyMapping, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretTargetSecret.
+func (in *SopsSecretTargetSecret) DeepCopy() *SopsSecretTargetSecret {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretTargetSecret)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *SopsSecretTargetStatus) DeepCopyInto(out *SopsSecretTargetStatus) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new SopsSecretTargetStatus.
+func (in *SopsSecretTargetStatus) DeepCopy() *SopsSecretTargetStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(SopsSecretTargetStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,87 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// previousOwnerAnnotation records the owners of an adopted Secret before it was adopted.
+const previousOwnerAnnotation = "craftypath.github.io/previous-owner"
+
+// previousOwner is the value of the previous owner annotation.
+type previousOwner struct {
+	OwnerReferences []metav1.OwnerReference `json:"ownerReferences,omitempty"`
+	ManagedBy       string                  `json:"managedBy,omitempty"`
+}
+
+// allowedAdoptionNamespacesAnnotation is the annotation of a namespace listing the namespaces, separated by
+// commas, whose SopsSecrets may adopt existing Secrets in it. The value "*" allows all namespaces.
+const allowedAdoptionNamespacesAnnotation = "craftypath.github.io/allowed-adoption-namespaces"
+
+// adopt takes over the given existing Secret that is not owned by the given SopsSecret
+// if the SopsSecret's adoption policy allows it. Owner references that do not control the Secret are kept.
+// Secrets in other namespaces are only adopted if their namespace opts in with allowedAdoptionNamespacesAnnotation.
+func (r *SopsSecretReconciler) adopt(ctx context.Context, secret *corev1.Secret, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) error {
+	switch sopsSecret.Spec.AdoptionPolicy {
+	case craftypathgithubiov1alpha1.AdoptionPolicyAdopt:
+	case craftypathgithubiov1alpha1.AdoptionPolicyAdoptIfLabeled:
+		if secret.Labels[managedByLabel] != managedByValue {
+			return fmt.Errorf("secret already exists and not owned by sops-operator")
+		}
+	default:
+		return fmt.Errorf("secret already exists and not owned by sops-operator")
+	}
+
+	if owner := metav1.GetControllerOf(secret); owner != nil {
+		return fmt.Errorf("secret already exists and is controlled by %s %q", owner.Kind, owner.Name)
+	}
+	if name, namespace := secret.Labels[ownerNameLabel], secret.Labels[ownerNamespaceLabel]; name != "" || namespace != "" {
+		return fmt.Errorf("secret already exists and is owned by SopsSecret %s/%s", namespace, name)
+	}
+
+	allowed, err := r.namespaceAllows(ctx, secret.Namespace, allowedAdoptionNamespacesAnnotation, sopsSecret.Namespace)
+	if err != nil {
+		return err
+	}
+	if !allowed {
+		return fmt.Errorf("secret already exists and namespace %q does not allow adoption, its annotation %s does not list namespace %q",
+			secret.Namespace, allowedAdoptionNamespacesAnnotation, sopsSecret.Namespace)
+	}
+
+	owner, err := json.Marshal(previousOwner{
+		OwnerReferences: secret.OwnerReferences,
+		ManagedBy:       secret.Labels[managedByLabel],
+	})
+	if err != nil {
+		return fmt.Errorf("unable to record previous owner: %w", err)
+	}
+	annotations := make(map[string]string, len(secret.Annotations)+1)
+	for key, value := range secret.Annotations {
+		annotations[key] = value
+	}
+	annotations[previousOwnerAnnotation] = string(owner)
+	secret.Annotations = annotations
+	return nil
+}
@@ -0,0 +1,260 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// fluxSourceGroup is the API group of Flux sources.
+const fluxSourceGroup = "source.toolkit.fluxcd.io"
+
+// fluxSourceKinds are the kinds of Flux sources whose artifacts can be referenced.
+var fluxSourceKinds = []craftypathgithubiov1alpha1.SourceKind{
+	craftypathgithubiov1alpha1.SourceKindGitRepository,
+	craftypathgithubiov1alpha1.SourceKindBucket,
+	craftypathgithubiov1alpha1.SourceKindOCIRepository,
+}
+
+// fluxSourceVersions returns the preferred versions of the Flux sources known to the given RESTMapper by kind.
+// Kinds whose CRDs are not installed are omitted.
+func fluxSourceVersions(mapper meta.RESTMapper) (map[craftypathgithubiov1alpha1.SourceKind]schema.GroupVersionKind, error) {
+	versions := make(map[craftypathgithubiov1alpha1.SourceKind]schema.GroupVersionKind)
+	for _, kind := range fluxSourceKinds {
+		mapping, err := mapper.RESTMapping(schema.GroupKind{Group: fluxSourceGroup, Kind: string(kind)})
+		if err != nil {
+			if meta.IsNoMatchError(err) {
+				continue
+			}
+			return nil, err
+		}
+		versions[kind] = mapping.GroupVersionKind
+	}
+	return versions, nil
+}
+
+// artifactRevisionChanged passes updates of Flux sources only if the digest or revision of their artifact
+// changed, so that other status updates of the source-controller do not trigger reconciliations.
+var artifactRevisionChanged = predicate.Funcs{
+	UpdateFunc: func(e event.UpdateEvent) bool {
+		return artifactRevision(e.ObjectOld) != artifactRevision(e.ObjectNew)
+	},
+}
+
+// artifactRevision returns the digest, checksum and revision of the artifact of the given Flux source.
+func artifactRevision(obj client.Object) [3]string {
+	src, ok := obj.(*unstructured.Unstructured)
+	if !ok {
+		return [3]string{}
+	}
+	var revision [3]string
+	for i, field := range []string{"digest", "checksum", "revision"} {
+		revision[i], _, _ = unstructured.NestedString(src.Object, "status", "artifact", field)
+	}
+	return revision
+}
+
+// maxArtifactSize is the maximum size in bytes of downloaded artifacts and of files extracted from them.
+const maxArtifactSize = 100 << 20
+
+// defaultHTTPClient downloads artifacts unless another client is configured.
+var defaultHTTPClient = &http.Client{Timeout: time.Minute}
+
+// artifactContent returns the encrypted content of the file referenced by the given source ref
+// from the artifact of the referenced Flux source.
+func (g *generator) artifactContent(ctx context.Context, namespace string, ref craftypathgithubiov1alpha1.SopsSecretSourceRef) (string, error) {
+	if ref.Path == "" {
+		return "", fmt.Errorf("path must be specified for source %s %q", ref.Kind, ref.Name)
+	}
+
+	gvk, installed := g.FluxSources[ref.Kind]
+	if !installed {
+		return "", &reasonError{
+			reason: reasonSourceNotFound,
+			err:    fmt.Errorf("source kind %s is not installed", ref.Kind),
+		}
+	}
+	src := &unstructured.Unstructured{}
+	src.SetGroupVersionKind(gvk)
+	if err := g.Get(ctx, types.NamespacedName{Namespace: namespace, Name: ref.Name}, src); err != nil {
+		return "", sourceGetError(ref, err)
+	}
+
+	url, _, _ := unstructured.NestedString(src.Object, "status", "artifact", "url")
+	if url == "" {
+		return "", &reasonError{
+			reason: reasonSourceNotFound,
+			err:    fmt.Errorf("source %s %q has no artifact", ref.Kind, ref.Name),
+		}
+	}
+	digest, _, _ := unstructured.NestedString(src.Object, "status", "artifact", "digest")
+	if digest == "" {
+		// Older versions of the source-controller only report the SHA-256 checksum.
+		checksum, _, _ := unstructured.NestedString(src.Object, "status", "artifact", "checksum")
+		digest = "sha256:" + checksum
+	}
+
+	artifact, err := g.fetchArtifact(ctx, url, digest)
+	if err != nil {
+		return "", &reasonError{
+			reason: reasonArtifactFailed,
+			err:    fmt.Errorf("failed to fetch artifact of source %s %q: %w", ref.Kind, ref.Name, err),
+		}
+	}
+
+	content, found, err := extractFile(artifact, ref.Path)
+	if err != nil {
+		return "", &reasonError{
+			reason: reasonArtifactFailed,
+			err:    fmt.Errorf("failed to extract artifact of source %s %q: %w", ref.Kind, ref.Name, err),
+		}
+	}
+	if !found {
+		return "", &reasonError{
+			reason: reasonSourceNotFound,
+			err:    fmt.Errorf("path %q not found in artifact of source %s %q", ref.Path, ref.Kind, ref.Name),
+		}
+	}
+	return string(content), nil
+}
+
+// fetchArtifact downloads the artifact at the given URL and verifies it against the given digest.
+// Artifacts are cached by URL, so that they are downloaded once per reconciliation.
+func (g *generator) fetchArtifact(ctx context.Context, url string, digest string) ([]byte, error) {
+	if artifact, exists := g.artifacts[url]; exists {
+		return artifact, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	httpClient := g.HTTPClient
+	if httpClient == nil {
+		httpClient = defaultHTTPClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %q", resp.Status)
+	}
+
+	artifact, err := io.ReadAll(io.LimitReader(resp.Body, maxArtifactSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(artifact) > maxArtifactSize {
+		return nil, fmt.Errorf("artifact exceeds %d bytes", maxArtifactSize)
+	}
+	if err := verifyDigest(artifact, digest); err != nil {
+		return nil, err
+	}
+
+	if g.artifacts == nil {
+		g.artifacts = make(map[string][]byte)
+	}
+	g.artifacts[url] = artifact
+	return artifact, nil
+}
+
+// verifyDigest checks that the given data matches the given digest in the form '<algorithm>:<hex>'.
+func verifyDigest(data []byte, digest string) error {
+	algorithm, expected := "", ""
+	if i := strings.Index(digest, ":"); i >= 0 {
+		algorithm, expected = digest[:i], digest[i+1:]
+	}
+	if expected == "" {
+		return errors.New("artifact has no digest")
+	}
+
+	var h hash.Hash
+	switch algorithm {
+	case "sha256":
+		h = sha256.New()
+	case "sha384":
+		h = sha512.New384()
+	case "sha512":
+		h = sha512.New()
+	default:
+		return fmt.Errorf("unsupported digest algorithm %q", algorithm)
+	}
+	h.Write(data)
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != strings.ToLower(expected) {
+		return fmt.Errorf("digest mismatch: expected %s, got %s:%s", digest, algorithm, actual)
+	}
+	return nil
+}
+
+// extractFile returns the content of the regular file at the given path in the given gzipped tarball.
+func extractFile(artifact []byte, filePath string) ([]byte, bool, error) {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(artifact))
+	if err != nil {
+		return nil, false, err
+	}
+	defer gzipReader.Close()
+
+	want := path.Clean("/" + filePath)
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if header.Typeflag != tar.TypeReg || path.Clean("/"+header.Name) != want {
+			continue
+		}
+
+		content, err := io.ReadAll(io.LimitReader(tarReader, maxArtifactSize+1))
+		if err != nil {
+			return nil, false, err
+		}
+		if len(content) > maxArtifactSize {
+			return nil, false, fmt.Errorf("file %q exceeds %d bytes", filePath, maxArtifactSize)
+		}
+		return content, true, nil
+	}
+}
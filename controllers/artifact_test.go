@@ -0,0 +1,327 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func newTarball(t *testing.T, files map[string]string) []byte {
+	var buf bytes.Buffer
+	gzipWriter := gzip.NewWriter(&buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: "secrets/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range files {
+		require.NoError(t, tarWriter.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := tarWriter.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tarWriter.Close())
+	require.NoError(t, gzipWriter.Close())
+	return buf.Bytes()
+}
+
+// testFluxSources are the versions Flux sources are served in by the fake client.
+var testFluxSources = map[v1alpha1.SourceKind]schema.GroupVersionKind{
+	v1alpha1.SourceKindGitRepository: {Group: fluxSourceGroup, Version: "v1", Kind: string(v1alpha1.SourceKindGitRepository)},
+	v1alpha1.SourceKindBucket:        {Group: fluxSourceGroup, Version: "v1beta2", Kind: string(v1alpha1.SourceKindBucket)},
+	v1alpha1.SourceKindOCIRepository: {Group: fluxSourceGroup, Version: "v1beta2", Kind: string(v1alpha1.SourceKindOCIRepository)},
+}
+
+func newFluxSource(kind v1alpha1.SourceKind, name string, artifact map[string]interface{}) *unstructured.Unstructured {
+	src := &unstructured.Unstructured{Object: map[string]interface{}{}}
+	src.SetGroupVersionKind(testFluxSources[kind])
+	src.SetName(name)
+	src.SetNamespace(namespace)
+	if artifact != nil {
+		src.Object["status"] = map[string]interface{}{"artifact": artifact}
+	}
+	return src
+}
+
+func TestReconcile_ArtifactSourceRefs(t *testing.T) {
+	tarball := newTarball(t, map[string]string{
+		"secrets/db.yaml": "encrypted db",
+		"./app.env":       "encrypted env",
+		"secrets/ignored": "ignored",
+	})
+	sum := sha256.Sum256(tarball)
+	checksum := hex.EncodeToString(sum[:])
+
+	var downloads int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/gitrepository/test/latest.tar.gz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		downloads++
+		_, _ = w.Write(tarball)
+	}))
+	defer server.Close()
+	url := server.URL + "/gitrepository/test/latest.tar.gz"
+
+	tests := []struct {
+		name          string
+		sources       []runtime.Object
+		refs          []v1alpha1.SopsSecretSourceRef
+		notInstalled  v1alpha1.SourceKind
+		wantEncrypted map[string]string
+		wantDownloads int
+		wantEvent     string
+		wantReason    string
+	}{
+		{
+			name: "git repository",
+			sources: []runtime.Object{
+				newFluxSource(v1alpha1.SourceKindGitRepository, "config", map[string]interface{}{"url": url, "digest": "sha256:" + checksum}),
+			},
+			refs: []v1alpha1.SopsSecretSourceRef{
+				{Kind: v1alpha1.SourceKindGitRepository, Name: "config", Path: "secrets/db.yaml"},
+				{Kind: v1alpha1.SourceKindGitRepository, Name: "config", Path: "/app.env", EntryName: "prod.env"},
+			},
+			wantEncrypted: map[string]string{"db.yaml": "encrypted db", "prod.env": "encrypted env"},
+			wantDownloads: 1,
+			wantEvent:     "Normal Created Created secret: test-secret",
+		},
+		{
+			name: "bucket with checksum",
+			sources: []runtime.Object{
+				newFluxSource(v1alpha1.SourceKindBucket, "config", map[string]interface{}{"url": url, "checksum": checksum}),
+			},
+			refs:          []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindBucket, Name: "config", Path: "./secrets/db.yaml"}},
+			wantEncrypted: map[string]string{"db.yaml": "encrypted db"},
+			wantDownloads: 1,
+			wantEvent:     "Normal Created Created secret: test-secret",
+		},
+		{
+			name:       "source not found",
+			refs:       []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindOCIRepository, Name: "config", Path: "db.yaml"}},
+			wantEvent:  `Warning SourceNotFound Failed to update secret: source OCIRepository "config" not found`,
+			wantReason: reasonSourceNotFound,
+		},
+		{
+			name:         "source kind not installed",
+			refs:         []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindOCIRepository, Name: "config", Path: "db.yaml"}},
+			notInstalled: v1alpha1.SourceKindOCIRepository,
+			wantEvent:    `Warning SourceNotFound Failed to update secret: source kind OCIRepository is not installed`,
+			wantReason:   reasonSourceNotFound,
+		},
+		{
+			name: "no artifact",
+			sources: []runtime.Object{
+				newFluxSource(v1alpha1.SourceKindOCIRepository, "config", nil),
+			},
+			refs:       []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindOCIRepository, Name: "config", Path: "db.yaml"}},
+			wantEvent:  `Warning SourceNotFound Failed to update secret: source OCIRepository "config" has no artifact`,
+			wantReason: reasonSourceNotFound,
+		},
+		{
+			name: "path not found",
+			sources: []runtime.Object{
+				newFluxSource(v1alpha1.SourceKindGitRepository, "config", map[string]interface{}{"url": url, "digest": "sha256:" + checksum}),
+			},
+			refs:          []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindGitRepository, Name: "config", Path: "secrets"}},
+			wantDownloads: 1,
+			wantEvent:     `Warning SourceNotFound Failed to update secret: path "secrets" not found in artifact of source GitRepository "config"`,
+			wantReason:    reasonSourceNotFound,
+		},
+		{
+			name: "digest mismatch",
+			sources: []runtime.Object{
+				newFluxSource(v1alpha1.SourceKindGitRepository, "config", map[string]interface{}{"url": url, "digest": "sha256:0123"}),
+			},
+			refs:          []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindGitRepository, Name: "config", Path: "secrets/db.yaml"}},
+			wantDownloads: 1,
+			wantEvent:     `Warning ArtifactFailed Failed to update secret: failed to fetch artifact of source GitRepository "config": digest mismatch: expected sha256:0123, got sha256:` + checksum,
+			wantReason:    reasonArtifactFailed,
+		},
+		{
+			name: "download failure",
+			sources: []runtime.Object{
+				newFluxSource(v1alpha1.SourceKindGitRepository, "config", map[string]interface{}{"url": server.URL + "/missing.tar.gz", "digest": "sha256:" + checksum}),
+			},
+			refs:       []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindGitRepository, Name: "config", Path: "secrets/db.yaml"}},
+			wantEvent:  `Warning ArtifactFailed Failed to update secret: failed to fetch artifact of source GitRepository "config": unexpected status "404 Not Found"`,
+			wantReason: reasonArtifactFailed,
+		},
+		{
+			name:       "missing path",
+			refs:       []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindGitRepository, Name: "config"}},
+			wantEvent:  `Warning ProcessingError Failed to update secret: path must be specified for source GitRepository "config"`,
+			wantReason: reasonProcessingError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			downloads = 0
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+			fluxSources := make(map[v1alpha1.SourceKind]schema.GroupVersionKind)
+			for kind, gvk := range testFluxSources {
+				s.AddKnownTypeWithName(gvk, &unstructured.Unstructured{})
+				if kind != tt.notInstalled {
+					fluxSources[kind] = gvk
+				}
+			}
+
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec:       v1alpha1.SopsSecretSpec{SourceRefs: tt.refs},
+			}
+			recorder := record.NewFakeRecorder(1)
+			decryptor := &FakeDecryptor{}
+			r := newSopsSecretReconciler(s, recorder, append(tt.sources, sopsSecret)...)
+			r.Decryptor = decryptor
+			r.HTTPClient = server.Client()
+			r.fluxSources = fluxSources
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvent, <-recorder.Events)
+			assert.Equal(t, tt.wantDownloads, downloads)
+
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+			if tt.wantReason != "" {
+				condition := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady)
+				require.NotNil(t, condition)
+				assert.Equal(t, metav1.ConditionFalse, condition.Status)
+				assert.Equal(t, tt.wantReason, condition.Reason)
+				return
+			}
+
+			assert.Equal(t, tt.wantEncrypted, decryptor.encrypted)
+			secret := &corev1.Secret{}
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+			for entry := range tt.wantEncrypted {
+				assert.Equal(t, []byte("unencrypted"), secret.Data[entry])
+			}
+		})
+	}
+}
+
+func TestVerifyDigest(t *testing.T) {
+	data := []byte("artifact")
+	tests := []struct {
+		name    string
+		digest  string
+		wantErr string
+	}{
+		{name: "sha256", digest: "sha256:c7c5c1d70c5dec4416ab6158afd0b223ef40c29b1dc1f97ed9428b94d4cadb1c"},
+		{name: "sha512", digest: "sha512:14697440701c3885f7c8d5faa59f336b471ca86332034eff0d3fddc02dc9b18b8356e840db54823c8fd2f2cbd0906969cf132cf8bb9c73dc769b4ffd817bd23d"},
+		{name: "unsupported", digest: "md5:abc", wantErr: `unsupported digest algorithm "md5"`},
+		{name: "empty", digest: "sha256:", wantErr: "artifact has no digest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyDigest(data, tt.digest)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestFluxSourceVersions(t *testing.T) {
+	mapper := meta.NewDefaultRESTMapper([]schema.GroupVersion{
+		{Group: fluxSourceGroup, Version: "v1"},
+		{Group: fluxSourceGroup, Version: "v1beta2"},
+	})
+	gitRepositoryV1 := schema.GroupVersionKind{Group: fluxSourceGroup, Version: "v1", Kind: string(v1alpha1.SourceKindGitRepository)}
+	bucketV1beta2 := schema.GroupVersionKind{Group: fluxSourceGroup, Version: "v1beta2", Kind: string(v1alpha1.SourceKindBucket)}
+	mapper.Add(gitRepositoryV1, meta.RESTScopeNamespace)
+	mapper.Add(schema.GroupVersionKind{Group: fluxSourceGroup, Version: "v1beta2", Kind: string(v1alpha1.SourceKindGitRepository)}, meta.RESTScopeNamespace)
+	mapper.Add(bucketV1beta2, meta.RESTScopeNamespace)
+
+	versions, err := fluxSourceVersions(mapper)
+	require.NoError(t, err)
+	assert.Equal(t, map[v1alpha1.SourceKind]schema.GroupVersionKind{
+		v1alpha1.SourceKindGitRepository: gitRepositoryV1,
+		v1alpha1.SourceKindBucket:        bucketV1beta2,
+	}, versions)
+}
+
+func TestArtifactRevisionChanged(t *testing.T) {
+	tests := []struct {
+		name        string
+		oldArtifact map[string]interface{}
+		newArtifact map[string]interface{}
+		want        bool
+	}{
+		{
+			name:        "unchanged",
+			oldArtifact: map[string]interface{}{"digest": "sha256:abc", "revision": "main@sha1:123", "lastUpdateTime": "2026-10-17T00:00:00Z"},
+			newArtifact: map[string]interface{}{"digest": "sha256:abc", "revision": "main@sha1:123", "lastUpdateTime": "2026-10-17T01:00:00Z"},
+		},
+		{
+			name:        "digest changed",
+			oldArtifact: map[string]interface{}{"digest": "sha256:abc", "revision": "main@sha1:123"},
+			newArtifact: map[string]interface{}{"digest": "sha256:def", "revision": "main@sha1:123"},
+			want:        true,
+		},
+		{
+			name:        "checksum changed",
+			oldArtifact: map[string]interface{}{"checksum": "abc"},
+			newArtifact: map[string]interface{}{"checksum": "def"},
+			want:        true,
+		},
+		{
+			name:        "revision changed",
+			oldArtifact: map[string]interface{}{"digest": "sha256:abc", "revision": "main@sha1:123"},
+			newArtifact: map[string]interface{}{"digest": "sha256:abc", "revision": "main@sha1:456"},
+			want:        true,
+		},
+		{
+			name:        "artifact added",
+			newArtifact: map[string]interface{}{"digest": "sha256:abc", "revision": "main@sha1:123"},
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, artifactRevisionChanged.Update(event.UpdateEvent{
+				ObjectOld: newFluxSource(v1alpha1.SourceKindGitRepository, "config", tt.oldArtifact),
+				ObjectNew: newFluxSource(v1alpha1.SourceKindGitRepository, "config", tt.newArtifact),
+			}))
+		})
+	}
+}
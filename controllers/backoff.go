@@ -0,0 +1,69 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// minRetryInterval is the interval after which the first failed reconciliation is retried.
+	minRetryInterval = 2 * time.Second
+	// maxRetryInterval is the upper limit of the interval between retries of failed reconciliations.
+	maxRetryInterval = 6 * time.Hour
+)
+
+// errorBackoff tracks the interval after which failed reconciliations are retried by object name, so that
+// it can be reset once the object is not found anymore. The interval doubles with each consecutive failure,
+// up to six hours. The zero value is ready to use.
+type errorBackoff struct {
+	mu        sync.Mutex
+	intervals map[types.NamespacedName]time.Duration
+}
+
+// next returns the time after which the failed reconciliation of the object with the given name is retried.
+// The interval starts over if the object was ready before the failure.
+func (b *errorBackoff) next(name types.NamespacedName, wasReady bool) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.intervals == nil {
+		b.intervals = make(map[types.NamespacedName]time.Duration)
+	}
+	interval, exists := b.intervals[name]
+	switch {
+	case !exists || wasReady:
+		interval = minRetryInterval
+	case interval*2 > maxRetryInterval:
+		interval = maxRetryInterval
+	default:
+		interval *= 2
+	}
+	b.intervals[name] = interval
+	return interval
+}
+
+// reset forgets the retry interval of the object with the given name after a successful reconciliation
+// or once the object is gone.
+func (b *errorBackoff) reset(name types.NamespacedName) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.intervals, name)
+}
@@ -0,0 +1,46 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestErrorBackoff(t *testing.T) {
+	var b errorBackoff
+	a := types.NamespacedName{Namespace: "test-namespace", Name: "a"}
+	other := types.NamespacedName{Namespace: "test-namespace", Name: "b"}
+	assert.Equal(t, 2*time.Second, b.next(a, false))
+	assert.Equal(t, 4*time.Second, b.next(a, false))
+	assert.Equal(t, 8*time.Second, b.next(a, false))
+	assert.Equal(t, 2*time.Second, b.next(other, false))
+
+	// Failures of ready objects start over
+	assert.Equal(t, 2*time.Second, b.next(a, true))
+
+	b.reset(a)
+	assert.Equal(t, 2*time.Second, b.next(a, false))
+
+	for i := 0; i < 20; i++ {
+		b.next(a, false)
+	}
+	assert.Equal(t, 6*time.Hour, b.next(a, false))
+}
@@ -0,0 +1,325 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// clusterOwnerLabel identifies the ClusterSopsSecret owning a Secret, so that Secrets
+// in namespaces that are no longer selected can be found and pruned.
+const clusterOwnerLabel = "craftypath.github.io/clustersopssecret-name"
+
+// ClusterSopsSecretReconciler reconciles a ClusterSopsSecret object.
+// It lists namespaces and Secrets across the cluster and thus requires a manager watching all namespaces.
+type ClusterSopsSecretReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	Decryptor Decryptor
+	// HashKey is the key the hashes in the status are keyed with, see LoadHashKey.
+	HashKey []byte
+
+	backoff errorBackoff
+}
+
+//+kubebuilder:rbac:groups=craftypath.github.io,resources=clustersopssecrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=craftypath.github.io,resources=clustersopssecrets/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+
+func (r *ClusterSopsSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.FromContext(ctx)
+	reqLogger.Info("reconciling ClusterSopsSecret")
+
+	instance := &craftypathgithubiov1alpha1.ClusterSopsSecret{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Objects deleted while failing are not retried anymore
+			r.backoff.reset(req.NamespacedName)
+		}
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	// Generated Secrets are garbage collected via their owner references
+	if !instance.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	namespaces, err := r.selectedNamespaces(ctx, instance)
+	if err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, err)
+	}
+	existing, err := r.generatedSecrets(ctx, instance)
+	if err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, err)
+	}
+
+	g := &generator{Reader: r.Client, Decryptor: r.Decryptor, HashKey: r.HashKey}
+	generated, keyStatuses, err := g.generate(ctx, sopsSecretForCluster(instance), previousClusterData(existing))
+	if keyStatuses != nil {
+		instance.Status.Keys = keyStatuses
+	}
+	if err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, fmt.Errorf("failed to update secret: %w", err))
+	}
+	if len(generated.failed) > 0 {
+		msg := fmt.Sprintf("failed to decrypt keys %s, keeping their last good values", strings.Join(generated.failed, ", "))
+		r.Recorder.Event(instance, "Warning", reasonDecryptionFailed, capitalizeFirst(msg))
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, metav1.ConditionFalse, reasonDecryptionFailed, msg)
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDegraded, metav1.ConditionTrue, reasonDecryptionFailed, msg)
+	} else {
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, metav1.ConditionTrue, reasonReconciled, "Data decrypted successfully")
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDegraded, metav1.ConditionFalse, reasonReconciled, "All keys decrypted successfully")
+	}
+	instance.Status.DataHash = hashData(r.HashKey, instance.UID, generated.data)
+
+	results := make([]controllerutil.OperationResult, len(namespaces))
+	statuses := make([]craftypathgithubiov1alpha1.ClusterSopsSecretNamespaceStatus, len(namespaces))
+	var failed []string
+	var syncErr error
+	for i, namespace := range namespaces {
+		statuses[i] = craftypathgithubiov1alpha1.ClusterSopsSecretNamespaceStatus{
+			Namespace: namespace,
+			Status:    "Success",
+		}
+		results[i], err = r.syncSecret(ctx, instance, namespace, generated)
+		if err != nil {
+			statuses[i].Status = "Failure"
+			statuses[i].Message = err.Error()
+			failed = append(failed, namespace)
+			if syncErr == nil {
+				syncErr = err
+			}
+		}
+	}
+	instance.Status.Namespaces = statuses
+
+	// Secrets are pruned from namespaces that are no longer selected regardless of failures in other namespaces
+	pruneErr := r.prune(ctx, instance, namespaces, existing)
+
+	if syncErr != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced,
+			fmt.Errorf("failed to sync secrets in namespaces %s: %w", strings.Join(failed, ", "), syncErr))
+	}
+	if pruneErr != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, pruneErr)
+	}
+
+	result, err := r.manageSuccess(ctx, instance, namespaces, results)
+	if err == nil && len(generated.failed) > 0 && result.RequeueAfter == 0 {
+		// Entries that could not be decrypted are retried periodically
+		result.RequeueAfter = degradedRetryInterval
+	}
+	return result, err
+}
+
+// sopsSecretForCluster returns a SopsSecret with the entries, options and key statuses of the given
+// ClusterSopsSecret, so that ClusterSopsSecrets are decrypted by the same pipeline as SopsSecrets.
+func sopsSecretForCluster(clusterSopsSecret *craftypathgithubiov1alpha1.ClusterSopsSecret) *craftypathgithubiov1alpha1.SopsSecret {
+	return &craftypathgithubiov1alpha1.SopsSecret{
+		ObjectMeta: clusterSopsSecret.ObjectMeta,
+		Spec: craftypathgithubiov1alpha1.SopsSecretSpec{
+			Metadata:      clusterSopsSecret.Spec.Metadata,
+			StringData:    clusterSopsSecret.Spec.StringData,
+			Data:          clusterSopsSecret.Spec.Data,
+			Options:       clusterSopsSecret.Spec.Options,
+			Template:      clusterSopsSecret.Spec.Template,
+			Type:          clusterSopsSecret.Spec.Type,
+			FailurePolicy: clusterSopsSecret.Spec.FailurePolicy,
+		},
+		Status: craftypathgithubiov1alpha1.SopsSecretStatus{
+			Keys: clusterSopsSecret.Status.Keys,
+		},
+	}
+}
+
+// selectedNamespaces returns the sorted names of the namespaces selected by the given ClusterSopsSecret.
+// Terminating namespaces are skipped, since no Secrets can be created in them.
+func (r *ClusterSopsSecretReconciler) selectedNamespaces(ctx context.Context, clusterSopsSecret *craftypathgithubiov1alpha1.ClusterSopsSecret) ([]string, error) {
+	selector, err := metav1.LabelSelectorAsSelector(&clusterSopsSecret.Spec.NamespaceSelector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespace selector: %w", err)
+	}
+	namespaceList := &corev1.NamespaceList{}
+	if err := r.List(ctx, namespaceList, client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, fmt.Errorf("unable to list namespaces: %w", err)
+	}
+
+	namespaces := make([]string, 0, len(namespaceList.Items))
+	for _, namespace := range namespaceList.Items {
+		if namespace.Status.Phase == corev1.NamespaceTerminating || !namespace.DeletionTimestamp.IsZero() {
+			continue
+		}
+		namespaces = append(namespaces, namespace.Name)
+	}
+	sort.Strings(namespaces)
+	return namespaces, nil
+}
+
+// generatedSecrets returns the Secrets generated from the given ClusterSopsSecret in all namespaces.
+func (r *ClusterSopsSecretReconciler) generatedSecrets(ctx context.Context, clusterSopsSecret *craftypathgithubiov1alpha1.ClusterSopsSecret) ([]corev1.Secret, error) {
+	secrets := &corev1.SecretList{}
+	if err := r.List(ctx, secrets, client.MatchingLabels{managedByLabel: managedByValue, clusterOwnerLabel: clusterSopsSecret.Name}); err != nil {
+		return nil, fmt.Errorf("unable to list secrets: %w", err)
+	}
+
+	var owned []corev1.Secret
+	for _, secret := range secrets.Items {
+		if metav1.IsControlledBy(&secret, clusterSopsSecret) {
+			owned = append(owned, secret)
+		}
+	}
+	return owned, nil
+}
+
+// previousClusterData returns the data of a previously generated Secret. All generated Secrets
+// hold the same data, so the first one in namespace order is used.
+func previousClusterData(secrets []corev1.Secret) map[string][]byte {
+	if len(secrets) == 0 {
+		return nil
+	}
+	first := secrets[0]
+	for _, secret := range secrets[1:] {
+		if secret.Namespace < first.Namespace {
+			first = secret
+		}
+	}
+	return first.Data
+}
+
+// syncSecret creates or updates the Secret generated from the given ClusterSopsSecret in the given namespace.
+func (r *ClusterSopsSecretReconciler) syncSecret(ctx context.Context, clusterSopsSecret *craftypathgithubiov1alpha1.ClusterSopsSecret, namespace string, generated *generatedSecret) (controllerutil.OperationResult, error) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      clusterSopsSecret.Name,
+			Namespace: namespace,
+		},
+	}
+
+	return ctrl.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		if !secret.CreationTimestamp.IsZero() && !metav1.IsControlledBy(secret, clusterSopsSecret) {
+			return fmt.Errorf("secret already exists and not owned by sops-operator")
+		}
+		// The type of existing Secrets cannot be changed
+		if !secret.CreationTimestamp.IsZero() && generated.secretType != "" && secret.Type != generated.secretType {
+			return fmt.Errorf("secret has type %q and cannot be changed to %q", secret.Type, generated.secretType)
+		}
+
+		secret.Annotations = generated.annotations
+		secret.Labels = mergeStringMaps(generated.labels, map[string]string{
+			managedByLabel:    managedByValue,
+			clusterOwnerLabel: clusterSopsSecret.Name,
+		})
+		secret.Data = generated.data
+		if generated.secretType != "" {
+			secret.Type = generated.secretType
+		}
+		if err := ctrl.SetControllerReference(clusterSopsSecret, secret, r.Scheme); err != nil {
+			return fmt.Errorf("unable to set ownerReference: %w", err)
+		}
+		return nil
+	})
+}
+
+// prune deletes the Secrets generated from the given ClusterSopsSecret in namespaces that are no longer selected.
+func (r *ClusterSopsSecretReconciler) prune(ctx context.Context, clusterSopsSecret *craftypathgithubiov1alpha1.ClusterSopsSecret, namespaces []string, existing []corev1.Secret) error {
+	selected := make(map[string]bool, len(namespaces))
+	for _, namespace := range namespaces {
+		selected[namespace] = true
+	}
+
+	for i := range existing {
+		secret := &existing[i]
+		if selected[secret.Namespace] {
+			continue
+		}
+		log.FromContext(ctx).Info("pruning secret", "namespace", secret.Namespace)
+		if err := r.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+			return fmt.Errorf("unable to delete secret %s/%s: %w", secret.Namespace, secret.Name, err)
+		}
+		r.Recorder.Event(clusterSopsSecret, "Normal", "Deleted", fmt.Sprintf("Deleted secret: %s/%s", secret.Namespace, secret.Name))
+	}
+	return nil
+}
+
+// manageError records the given error as the reason of the given condition being false and requeues the ClusterSopsSecret.
+func (r *ClusterSopsSecretReconciler) manageError(ctx context.Context, instance *craftypathgithubiov1alpha1.ClusterSopsSecret, conditionType string, issue error) (reconcile.Result, error) {
+	return manageError(ctx, r.Client, r.Recorder, &r.backoff, instance, conditionType, issue)
+}
+
+func (r *ClusterSopsSecretReconciler) manageSuccess(ctx context.Context, instance *craftypathgithubiov1alpha1.ClusterSopsSecret, namespaces []string, results []controllerutil.OperationResult) (reconcile.Result, error) {
+	if result, ok := manageSuccess(ctx, r.Client, r.Recorder, &r.backoff, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, "Secrets synced successfully"); !ok {
+		return result, nil
+	}
+
+	logger := log.FromContext(ctx)
+	for i, result := range results {
+		if result == controllerutil.OperationResultNone {
+			continue
+		}
+		opResult := capitalizeFirst(string(result))
+		msg := fmt.Sprintf("%s secret: %s/%s", opResult, namespaces[i], instance.Name)
+		logger.Info("status updated successfully: " + msg)
+		r.Recorder.Event(instance, "Normal", opResult, msg)
+	}
+	return reconcile.Result{}, nil
+}
+
+// findClusterSopsSecretsForNamespace returns requests for all ClusterSopsSecrets, since any of them may
+// have to create a Secret in a new or relabeled namespace or prune it from there.
+func (r *ClusterSopsSecretReconciler) findClusterSopsSecretsForNamespace(obj client.Object) []reconcile.Request {
+	clusterSopsSecrets := &craftypathgithubiov1alpha1.ClusterSopsSecretList{}
+	if err := r.List(context.Background(), clusterSopsSecrets); err != nil {
+		log.Log.Error(err, "unable to list ClusterSopsSecrets")
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(clusterSopsSecrets.Items))
+	for _, clusterSopsSecret := range clusterSopsSecrets.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Name: clusterSopsSecret.Name},
+		})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *ClusterSopsSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&craftypathgithubiov1alpha1.ClusterSopsSecret{}).
+		Owns(&corev1.Secret{}).
+		Watches(&source.Kind{Type: &corev1.Namespace{}}, handler.EnqueueRequestsFromMapFunc(r.findClusterSopsSecretsForNamespace)).
+		Complete(r)
+}
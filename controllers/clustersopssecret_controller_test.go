@@ -0,0 +1,159 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+var clusterReq = reconcile.Request{NamespacedName: types.NamespacedName{Name: "shared"}}
+
+func newClusterSopsSecretReconciler(recorder *record.FakeRecorder, objs ...runtime.Object) *ClusterSopsSecretReconciler {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+	return &ClusterSopsSecretReconciler{
+		Client:    cl,
+		Scheme:    s,
+		Recorder:  recorder,
+		Decryptor: &FakeDecryptor{},
+	}
+}
+
+func newLabeledNamespace(name string, labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func newClusterSopsSecret() *v1alpha1.ClusterSopsSecret {
+	return &v1alpha1.ClusterSopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec: v1alpha1.ClusterSopsSecretSpec{
+			NamespaceSelector: metav1.LabelSelector{MatchLabels: map[string]string{"pull-secrets": "enabled"}},
+			Type:              corev1.SecretTypeOpaque,
+			StringData:        map[string]string{"test.yaml": "encrypted"},
+		},
+	}
+}
+
+func TestReconcileClusterSopsSecret(t *testing.T) {
+	enabled := map[string]string{"pull-secrets": "enabled"}
+	terminating := newLabeledNamespace("terminating", enabled)
+	terminating.Status.Phase = corev1.NamespaceTerminating
+
+	recorder := record.NewFakeRecorder(3)
+	r := newClusterSopsSecretReconciler(recorder,
+		newClusterSopsSecret(),
+		newLabeledNamespace("a", enabled),
+		newLabeledNamespace("b", enabled),
+		newLabeledNamespace("c", nil),
+		terminating,
+	)
+
+	res, err := r.Reconcile(context.Background(), clusterReq)
+	require.NoError(t, err)
+	assert.False(t, res.Requeue)
+	assert.Equal(t, "Normal Created Created secret: a/shared", <-recorder.Events)
+	assert.Equal(t, "Normal Created Created secret: b/shared", <-recorder.Events)
+
+	clusterSopsSecret := &v1alpha1.ClusterSopsSecret{}
+	require.NoError(t, r.Get(context.Background(), clusterReq.NamespacedName, clusterSopsSecret))
+	for _, namespace := range []string{"a", "b"} {
+		secret := &corev1.Secret{}
+		require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "shared"}, secret))
+		assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+		assert.Equal(t, corev1.SecretTypeOpaque, secret.Type)
+		assert.True(t, metav1.IsControlledBy(secret, clusterSopsSecret))
+	}
+	assert.True(t, meta.IsStatusConditionTrue(clusterSopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+	assert.Equal(t, []v1alpha1.ClusterSopsSecretNamespaceStatus{
+		{Namespace: "a", Status: "Success"},
+		{Namespace: "b", Status: "Success"},
+	}, clusterSopsSecret.Status.Namespaces)
+
+	// relabeling namespaces creates the Secret in newly selected namespaces and prunes it from others
+	for name, labels := range map[string]map[string]string{"b": nil, "c": enabled} {
+		namespace := &corev1.Namespace{}
+		require.NoError(t, r.Get(context.Background(), types.NamespacedName{Name: name}, namespace))
+		namespace.Labels = labels
+		require.NoError(t, r.Update(context.Background(), namespace))
+	}
+	assert.Len(t, r.findClusterSopsSecretsForNamespace(&corev1.Namespace{}), 1)
+
+	_, err = r.Reconcile(context.Background(), clusterReq)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Deleted Deleted secret: b/shared", <-recorder.Events)
+	assert.Equal(t, "Normal Created Created secret: c/shared", <-recorder.Events)
+
+	err = r.Get(context.Background(), types.NamespacedName{Namespace: "b", Name: "shared"}, &corev1.Secret{})
+	assert.True(t, apierrors.IsNotFound(err))
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "c", Name: "shared"}, &corev1.Secret{}))
+	require.NoError(t, r.Get(context.Background(), clusterReq.NamespacedName, clusterSopsSecret))
+	assert.Equal(t, []v1alpha1.ClusterSopsSecretNamespaceStatus{
+		{Namespace: "a", Status: "Success"},
+		{Namespace: "c", Status: "Success"},
+	}, clusterSopsSecret.Status.Namespaces)
+}
+
+func TestReconcileClusterSopsSecret_NamespaceFailure(t *testing.T) {
+	enabled := map[string]string{"pull-secrets": "enabled"}
+	unowned := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: "b", CreationTimestamp: metav1.Now()},
+	}
+
+	recorder := record.NewFakeRecorder(2)
+	r := newClusterSopsSecretReconciler(recorder,
+		newClusterSopsSecret(),
+		newLabeledNamespace("a", enabled),
+		newLabeledNamespace("b", enabled),
+		unowned,
+	)
+
+	res, err := r.Reconcile(context.Background(), clusterReq)
+	require.NoError(t, err)
+	assert.True(t, res.Requeue)
+	assert.Equal(t, "Warning ProcessingError Failed to sync secrets in namespaces b: secret already exists and not owned by sops-operator", <-recorder.Events)
+
+	// the Secret is still created in other namespaces
+	require.NoError(t, r.Get(context.Background(), types.NamespacedName{Namespace: "a", Name: "shared"}, &corev1.Secret{}))
+
+	clusterSopsSecret := &v1alpha1.ClusterSopsSecret{}
+	require.NoError(t, r.Get(context.Background(), clusterReq.NamespacedName, clusterSopsSecret))
+	assert.False(t, meta.IsStatusConditionTrue(clusterSopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+	assert.False(t, meta.IsStatusConditionTrue(clusterSopsSecret.Status.Conditions, v1alpha1.ConditionTypeSecretSynced))
+	assert.Equal(t, []v1alpha1.ClusterSopsSecretNamespaceStatus{
+		{Namespace: "a", Status: "Success"},
+		{Namespace: "b", Status: "Failure", Message: "secret already exists and not owned by sops-operator"},
+	}, clusterSopsSecret.Status.Namespaces)
+}
@@ -0,0 +1,134 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// finalizerName is the finalizer added to SopsSecrets in order to delete or orphan generated Secrets.
+const finalizerName = "craftypath.github.io/sopssecret"
+
+// finalize deletes or orphans the Secrets generated for the given SopsSecret according to its deletion policy
+// and removes the finalizer.
+func (r *SopsSecretReconciler) finalize(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) (reconcile.Result, error) {
+	if !controllerutil.ContainsFinalizer(sopsSecret, finalizerName) {
+		return reconcile.Result{}, nil
+	}
+
+	log.FromContext(ctx).Info("finalizing SopsSecret", "deletionPolicy", sopsSecret.Spec.DeletionPolicy)
+	cleanup := r.cleanupFor(sopsSecret)
+	targets := previousTargetsFor(sopsSecret)
+	for _, target := range targetsFor(sopsSecret) {
+		targets = append(targets, target.ref)
+	}
+	for _, target := range targets {
+		if err := cleanup(ctx, sopsSecret, target); err != nil {
+			return r.manageError(ctx, sopsSecret, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, err)
+		}
+	}
+
+	controllerutil.RemoveFinalizer(sopsSecret, finalizerName)
+	if err := r.Update(ctx, sopsSecret); err != nil {
+		return reconcile.Result{}, fmt.Errorf("unable to remove finalizer: %w", err)
+	}
+	r.backoff.reset(client.ObjectKeyFromObject(sopsSecret))
+	return reconcile.Result{}, nil
+}
+
+// needsFinalizer returns whether the given SopsSecret needs the finalizer for its generated Secrets to be
+// handled on deletion. Secrets in the namespace of a SopsSecret with the Delete policy are owned by the
+// SopsSecret and removed by the garbage collector, so the finalizer is only needed for the Orphan policy or
+// for targets in other namespaces, including previous targets that have not been cleaned up yet.
+func needsFinalizer(sopsSecret *craftypathgithubiov1alpha1.SopsSecret, targets []generatedTarget) bool {
+	if sopsSecret.Spec.DeletionPolicy == craftypathgithubiov1alpha1.DeletionPolicyOrphan {
+		return true
+	}
+	refs := previousTargetsFor(sopsSecret)
+	for _, target := range targets {
+		refs = append(refs, target.ref)
+	}
+	for _, ref := range refs {
+		if ref.Namespace != sopsSecret.Namespace {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanupFor returns the function deleting or orphaning Secrets that are no longer generated for the
+// given SopsSecret according to its deletion policy. Secrets in namespaces that do not allow the SopsSecret
+// to generate Secrets in them are left untouched.
+func (r *SopsSecretReconciler) cleanupFor(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) func(context.Context, *craftypathgithubiov1alpha1.SopsSecret, corev1.SecretReference) error {
+	cleanup := r.deleteTarget
+	if sopsSecret.Spec.DeletionPolicy == craftypathgithubiov1alpha1.DeletionPolicyOrphan {
+		cleanup = r.orphanTarget
+	}
+	return func(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, target corev1.SecretReference) error {
+		allowed, err := r.targetNamespaceAllowed(ctx, sopsSecret, target.Namespace)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			log.FromContext(ctx).Info("not cleaning up secret in a namespace that does not allow the SopsSecret", "secret", target)
+			return nil
+		}
+		return cleanup(ctx, sopsSecret, target)
+	}
+}
+
+// orphanTarget removes the ownership of the given SopsSecret from the given Secret.
+func (r *SopsSecretReconciler) orphanTarget(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, target corev1.SecretReference) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: target.Namespace, Name: target.Name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get secret %s/%s: %w", target.Namespace, target.Name, err)
+	}
+	if !isOwnedBy(secret, sopsSecret) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("orphaning secret", "secret", target)
+	ownerReferences := secret.OwnerReferences[:0]
+	for _, ref := range secret.OwnerReferences {
+		if ref.UID != sopsSecret.UID {
+			ownerReferences = append(ownerReferences, ref)
+		}
+	}
+	secret.OwnerReferences = ownerReferences
+	delete(secret.Labels, managedByLabel)
+	delete(secret.Labels, ownerNameLabel)
+	delete(secret.Labels, ownerNamespaceLabel)
+
+	if err := r.Update(ctx, secret); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to orphan secret %s/%s: %w", target.Namespace, target.Name, err)
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// secretDrift describes how a generated Secret differs from the data it was last synced with.
+type secretDrift struct {
+	secret   string
+	keys     []string
+	labels   []string
+	managers []string
+}
+
+func (d *secretDrift) String() string {
+	var changes []string
+	if len(d.keys) > 0 {
+		changes = append(changes, "keys "+strings.Join(d.keys, ", "))
+	}
+	if len(d.labels) > 0 {
+		changes = append(changes, "labels "+strings.Join(d.labels, ", "))
+	}
+	msg := fmt.Sprintf("%s of secret %s changed", strings.Join(changes, " and "), d.secret)
+	if len(d.managers) > 0 {
+		msg += " by " + strings.Join(d.managers, ", ")
+	}
+	return msg
+}
+
+// driftChecks selects the parts of generated Secrets that are checked for drift.
+type driftChecks struct {
+	// data is set if the generated data did not change since the Secrets were last synced.
+	data bool
+	// labels is set if neither the generated data nor the spec changed since the Secrets were last synced.
+	labels bool
+}
+
+// detectDrift compares the data and, if selected, the labels of the given existing Secret to those of the
+// desired Secret and returns the differences, or nil if there are none. The field managers owning the changed
+// fields according to the managed fields of the existing Secret are reported as the authors of the changes.
+func detectDrift(existing, desired *corev1.Secret, checks driftChecks) *secretDrift {
+	var keys, labels []string
+	if checks.data {
+		keys = changedKeys(existing.Data, desired.Data)
+	}
+	if checks.labels {
+		labels = changedLabels(existing.Labels, desired.Labels)
+	}
+	if len(keys) == 0 && len(labels) == 0 {
+		return nil
+	}
+
+	drift := &secretDrift{secret: desired.Name, keys: keys, labels: labels}
+	managers := map[string]bool{}
+	for _, entry := range existing.ManagedFields {
+		if entry.FieldsV1 == nil {
+			continue
+		}
+		owned := map[string]map[string]json.RawMessage{}
+		if err := json.Unmarshal(entry.FieldsV1.Raw, &owned); err != nil {
+			continue
+		}
+		ownedLabels := map[string]json.RawMessage{}
+		if raw, exists := owned["f:metadata"]["f:labels"]; exists {
+			_ = json.Unmarshal(raw, &ownedLabels)
+		}
+		if ownsAny(owned["f:data"], keys) || ownsAny(ownedLabels, labels) {
+			managers[entry.Manager] = true
+		}
+	}
+	for manager := range managers {
+		drift.managers = append(drift.managers, manager)
+	}
+	sort.Strings(drift.managers)
+	return drift
+}
+
+// ownsAny returns whether the given managed fields contain any of the given names.
+func ownsAny(fields map[string]json.RawMessage, names []string) bool {
+	for _, name := range names {
+		if _, exists := fields["f:"+name]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+// changedKeys returns the sorted keys whose values differ between the given Secret data.
+func changedKeys(existing, desired map[string][]byte) []string {
+	var keys []string
+	for key, value := range existing {
+		if desiredValue, exists := desired[key]; !exists || !bytes.Equal(value, desiredValue) {
+			keys = append(keys, key)
+		}
+	}
+	for key := range desired {
+		if _, exists := existing[key]; !exists {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// changedLabels returns the sorted names of the labels whose values differ between the given labels.
+func changedLabels(existing, desired map[string]string) []string {
+	var labels []string
+	for name, value := range existing {
+		if desiredValue, exists := desired[name]; !exists || value != desiredValue {
+			labels = append(labels, name)
+		}
+	}
+	for name := range desired {
+		if _, exists := existing[name]; !exists {
+			labels = append(labels, name)
+		}
+	}
+	sort.Strings(labels)
+	return labels
+}
@@ -0,0 +1,272 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func TestReconcile_DriftPolicy(t *testing.T) {
+	const drift = "keys extra, test.yaml and labels team of secret test-secret changed by kubectl-edit"
+
+	tests := []struct {
+		name          string
+		policy        v1alpha1.DriftPolicy
+		wantEvents    []string
+		wantData      map[string][]byte
+		wantCondition *metav1.Condition
+	}{
+		{
+			name: "correct by default",
+			wantEvents: []string{
+				"Warning DriftCorrected Corrected drift: " + drift,
+				"Normal Updated Updated secret: test-secret",
+			},
+			wantData: map[string][]byte{"test.yaml": []byte("unencrypted")},
+		},
+		{
+			name:   "report",
+			policy: v1alpha1.DriftPolicyReport,
+			wantData: map[string][]byte{
+				"test.yaml": []byte("edited"),
+				"extra":     []byte("extra"),
+			},
+			wantCondition: &metav1.Condition{Status: metav1.ConditionTrue, Reason: reasonDriftDetected, Message: drift},
+		},
+		{
+			name:   "ignore",
+			policy: v1alpha1.DriftPolicyIgnore,
+			wantData: map[string][]byte{
+				"test.yaml": []byte("edited"),
+				"extra":     []byte("extra"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					Metadata:    v1alpha1.SopsSecretObjectMeta{Labels: map[string]string{"app": "test"}},
+					StringData:  map[string]string{"test.yaml": "encrypted"},
+					DriftPolicy: tt.policy,
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(2)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+			decryptor := r.Decryptor.(*FakeDecryptor)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+			// The Secret is edited by hand
+			secret := &corev1.Secret{}
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+			secret.Data["test.yaml"] = []byte("edited")
+			secret.Data["extra"] = []byte("extra")
+			secret.Labels["team"] = "ops"
+			// The fake client does not set the creation timestamp of created objects
+			secret.CreationTimestamp = metav1.Now()
+			secret.ManagedFields = []metav1.ManagedFieldsEntry{
+				{
+					Manager:    "manager",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:data":{".":{}},"f:metadata":{"f:labels":{".":{},"f:app":{}}},"f:type":{}}`)},
+				},
+				{
+					Manager:    "kubectl-edit",
+					Operation:  metav1.ManagedFieldsOperationUpdate,
+					FieldsType: "FieldsV1",
+					FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:data":{"f:extra":{},"f:test.yaml":{}},"f:metadata":{"f:labels":{"f:team":{}}}}`)},
+				},
+			}
+			require.NoError(t, r.Update(context.Background(), secret))
+
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			for _, event := range tt.wantEvents {
+				assert.Equal(t, event, <-recorder.Events)
+			}
+			assert.Empty(t, recorder.Events)
+
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+			assert.Equal(t, tt.wantData, secret.Data)
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+			assert.True(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+			drifted := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDrifted)
+			if tt.wantCondition == nil {
+				assert.Nil(t, drifted)
+			} else {
+				require.NotNil(t, drifted)
+				assert.Equal(t, tt.wantCondition.Status, drifted.Status)
+				assert.Equal(t, tt.wantCondition.Reason, drifted.Reason)
+				assert.Equal(t, tt.wantCondition.Message, drifted.Message)
+			}
+
+			// Drifted Secrets are updated when the generated data changes
+			decryptor.decrypted = "rotated"
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+			assert.Equal(t, map[string][]byte{"test.yaml": []byte("rotated")}, secret.Data)
+			assert.NotContains(t, secret.Labels, "team")
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+			if tt.policy == v1alpha1.DriftPolicyReport {
+				assert.True(t, meta.IsStatusConditionFalse(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDrifted))
+			}
+		})
+	}
+}
+
+func TestReconcile_DriftAfterSpecChange(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Generation: 1},
+		Spec: v1alpha1.SopsSecretSpec{
+			Metadata:   v1alpha1.SopsSecretObjectMeta{Labels: map[string]string{"app": "test"}},
+			StringData: map[string]string{"test.yaml": "encrypted"},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(2)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+	// The Secret is edited by hand
+	secret := &corev1.Secret{}
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+	secret.Data["test.yaml"] = []byte("edited")
+	// The fake client does not set the creation timestamp of created objects
+	secret.CreationTimestamp = metav1.Now()
+	require.NoError(t, r.Update(context.Background(), secret))
+
+	// A change of the spec that leaves the generated data as it is does not hide the drift of the data,
+	// while the changed labels are applied
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	sopsSecret.Generation = 2
+	sopsSecret.Spec.Metadata.Labels["app"] = "other"
+	require.NoError(t, r.Update(context.Background(), sopsSecret))
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Warning DriftCorrected Corrected drift: keys test.yaml of secret test-secret changed", <-recorder.Events)
+	assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+	assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+	assert.Equal(t, "other", secret.Labels["app"])
+}
+
+func TestReconcile_NoDriftAfterFailedSync(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1alpha1.SopsSecretSpec{
+			DriftPolicy: v1alpha1.DriftPolicyReport,
+			StringData:  map[string]string{"test.yaml": "encrypted"},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(2)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+	secret := &corev1.Secret{}
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+	// The fake client does not set the creation timestamp of created objects
+	secret.CreationTimestamp = metav1.Now()
+	require.NoError(t, r.Update(context.Background(), secret))
+
+	// The data changes, but the Secret cannot be updated
+	r.Decryptor.(*FakeDecryptor).decrypted = "changed"
+	cl := r.Client
+	r.Client = &failingSecretUpdateClient{Client: cl}
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Warning ProcessingError Update failed", <-recorder.Events)
+
+	// The Secret that was not updated has not drifted
+	r.Client = cl
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+	assert.Equal(t, []byte("changed"), secret.Data["test.yaml"])
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	assert.True(t, meta.IsStatusConditionFalse(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDrifted))
+}
+
+func TestDetectDrift(t *testing.T) {
+	desired := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Labels: map[string]string{"app": "test"}},
+		Data:       map[string][]byte{"a": []byte("a"), "b": []byte("b")},
+	}
+
+	assert.Nil(t, detectDrift(desired.DeepCopy(), desired, driftChecks{data: true, labels: true}))
+
+	existing := desired.DeepCopy()
+	delete(existing.Data, "b")
+	existing.Labels["app"] = "other"
+	drift := detectDrift(existing, desired, driftChecks{data: true, labels: true})
+	require.NotNil(t, drift)
+	assert.Equal(t, "keys b and labels app of secret test-secret changed", drift.String())
+
+	drift = detectDrift(existing, desired, driftChecks{data: true})
+	require.NotNil(t, drift)
+	assert.Equal(t, "keys b of secret test-secret changed", drift.String())
+
+	existing.Data = desired.Data
+	assert.Nil(t, detectDrift(existing, desired, driftChecks{data: true}))
+}
@@ -0,0 +1,112 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/util/validation"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
+)
+
+// expand splits the decrypted document of the given entry into one Secret key per field
+// according to the given options.
+func expand(fileName string, format string, decrypted []byte, options *craftypathgithubiov1alpha1.SopsSecretExpand) (map[string][]byte, error) {
+	doc, err := sops.ParseDocument(format, decrypted)
+	if err != nil {
+		return nil, fmt.Errorf("unable to expand %q: %w", fileName, err)
+	}
+
+	mode := options.Mode
+	if mode == "" {
+		mode = craftypathgithubiov1alpha1.ExpandModeTopLevel
+	}
+
+	data := map[string][]byte{}
+	if err := expandFields(data, options.Prefix, doc, mode); err != nil {
+		return nil, fmt.Errorf("unable to expand %q: %w", fileName, err)
+	}
+	return data, nil
+}
+
+func expandFields(data map[string][]byte, prefix string, fields map[string]interface{}, mode craftypathgithubiov1alpha1.ExpandMode) error {
+	for _, field := range sortedKeys(fields) {
+		value := fields[field]
+		key := prefix + field
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if mode != craftypathgithubiov1alpha1.ExpandModeFlatten {
+				return fmt.Errorf("field %q has a nested value which cannot be represented with mode %s, use mode %s instead",
+					key, mode, craftypathgithubiov1alpha1.ExpandModeFlatten)
+			}
+			if err := expandFields(data, key+".", v, mode); err != nil {
+				return err
+			}
+			continue
+		case []interface{}:
+			return fmt.Errorf("field %q has a list value which cannot be represented as a Secret key", key)
+		}
+
+		if errs := validation.IsConfigMapKey(key); len(errs) > 0 {
+			return fmt.Errorf("field %q is not a valid Secret key: %s", key, strings.Join(errs, ", "))
+		}
+		if _, exists := data[key]; exists {
+			return fmt.Errorf("field %q results in a duplicate key", key)
+		}
+		data[key] = []byte(scalarToString(value))
+	}
+	return nil
+}
+
+func sortedKeys(fields map[string]interface{}) []string {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortedDataKeys(data map[string][]byte) []string {
+	keys := make([]string, 0, len(data))
+	for key := range data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func scalarToString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return fmt.Sprint(v)
+	}
+}
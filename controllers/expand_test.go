@@ -0,0 +1,159 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
+)
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		name      string
+		fileName  string
+		format    string
+		decrypted string
+		options   v1alpha1.SopsSecretExpand
+		want      map[string][]byte
+		wantErr   string
+	}{
+		{
+			name:      "yaml top-level",
+			fileName:  "db.yaml",
+			decrypted: "user: admin\npassword: s3cr3t\nport: 5432\nssl: true\n",
+			want: map[string][]byte{
+				"user":     []byte("admin"),
+				"password": []byte("s3cr3t"),
+				"port":     []byte("5432"),
+				"ssl":      []byte("true"),
+			},
+		},
+		{
+			name:      "json with prefix",
+			fileName:  "db.json",
+			decrypted: `{"user": "admin", "password": "s3cr3t"}`,
+			options:   v1alpha1.SopsSecretExpand{Prefix: "DB_"},
+			want: map[string][]byte{
+				"DB_user":     []byte("admin"),
+				"DB_password": []byte("s3cr3t"),
+			},
+		},
+		{
+			name:      "dotenv",
+			fileName:  "db.env",
+			decrypted: "# comment\nUSER=admin\nPASSWORD=s3=cr3t\n",
+			want: map[string][]byte{
+				"USER":     []byte("admin"),
+				"PASSWORD": []byte("s3=cr3t"),
+			},
+		},
+		{
+			name:      "ini flattened",
+			fileName:  "db.ini",
+			decrypted: "host = localhost\n[db]\nuser = admin\n",
+			options:   v1alpha1.SopsSecretExpand{Mode: v1alpha1.ExpandModeFlatten},
+			want: map[string][]byte{
+				"host":    []byte("localhost"),
+				"db.user": []byte("admin"),
+			},
+		},
+		{
+			name:      "yaml flattened",
+			fileName:  "db.yaml",
+			decrypted: "db:\n  user: admin\n  credentials:\n    password: s3cr3t\n",
+			options:   v1alpha1.SopsSecretExpand{Mode: v1alpha1.ExpandModeFlatten},
+			want: map[string][]byte{
+				"db.user":                 []byte("admin"),
+				"db.credentials.password": []byte("s3cr3t"),
+			},
+		},
+		{
+			name:      "nested value with top-level mode",
+			fileName:  "db.yaml",
+			decrypted: "db:\n  user: admin\n",
+			wantErr:   `unable to expand "db.yaml": field "db" has a nested value which cannot be represented with mode TopLevel, use mode Flatten instead`,
+		},
+		{
+			name:      "list value",
+			fileName:  "db.yaml",
+			decrypted: "db:\n  hosts:\n  - a\n  - b\n",
+			options:   v1alpha1.SopsSecretExpand{Mode: v1alpha1.ExpandModeFlatten},
+			wantErr:   `unable to expand "db.yaml": field "db.hosts" has a list value which cannot be represented as a Secret key`,
+		},
+		{
+			name:      "duplicate key",
+			fileName:  "db.yaml",
+			decrypted: "db.user: admin\ndb:\n  user: root\n",
+			options:   v1alpha1.SopsSecretExpand{Mode: v1alpha1.ExpandModeFlatten},
+			wantErr:   `unable to expand "db.yaml": field "db.user" results in a duplicate key`,
+		},
+		{
+			name:      "invalid key",
+			fileName:  "db.yaml",
+			decrypted: "user name: admin\n",
+			wantErr:   `unable to expand "db.yaml": field "user name" is not a valid Secret key`,
+		},
+		{
+			name:      "format override",
+			fileName:  "db",
+			format:    "yaml",
+			decrypted: "user: admin\n",
+			want:      map[string][]byte{"user": []byte("admin")},
+		},
+		{
+			name:      "invalid ini",
+			fileName:  "db.ini",
+			decrypted: "[db]\npassword s3cr3t\n",
+			wantErr:   `unable to expand "db.ini": unable to parse decrypted ini document`,
+		},
+		{
+			name:      "invalid yaml",
+			fileName:  "db.yaml",
+			decrypted: "password: s3cr3t\n- admin\n",
+			wantErr:   `unable to expand "db.yaml": unable to parse decrypted yaml document`,
+		},
+		{
+			name:      "binary",
+			fileName:  "db.bin",
+			decrypted: "binary",
+			wantErr:   `unable to expand "db.bin": documents of format binary cannot be parsed`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			format := tt.format
+			if format == "" {
+				format = sops.FileFormat(tt.fileName)
+			}
+			data, err := expand(tt.fileName, format, []byte(tt.decrypted), &tt.options)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				// errors must not disclose decrypted values
+				assert.NotContains(t, err.Error(), "s3cr3t")
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, data)
+		})
+	}
+}
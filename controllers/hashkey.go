@@ -0,0 +1,67 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// hashKeyDataKey is the key of the Secret holding the operator's hash key.
+const hashKeyDataKey = "hash-key"
+
+// hashKeySize is the size in bytes of generated hash keys.
+const hashKeySize = 32
+
+// LoadHashKey returns the key the hashes in the status of SopsSecrets, SopsConfigMaps and
+// ClusterSopsSecrets are keyed with. It is read from the given Secret, which is created with
+// a random key if it does not exist, so that the hashes remain stable across restarts.
+func LoadHashKey(ctx context.Context, c client.Client, ref types.NamespacedName) ([]byte, error) {
+	secret := &corev1.Secret{}
+	err := c.Get(ctx, ref, secret)
+	if apierrors.IsNotFound(err) {
+		key := make([]byte, hashKeySize)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("unable to generate hash key: %w", err)
+		}
+		secret = &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: ref.Namespace},
+			Data:       map[string][]byte{hashKeyDataKey: key},
+		}
+		err = c.Create(ctx, secret)
+		if apierrors.IsAlreadyExists(err) {
+			// Another replica created the Secret first
+			err = c.Get(ctx, ref, secret)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to load hash key from secret %s: %w", ref, err)
+	}
+
+	key := secret.Data[hashKeyDataKey]
+	if len(key) == 0 {
+		return nil, fmt.Errorf("secret %s has no key %q", ref, hashKeyDataKey)
+	}
+	return key, nil
+}
@@ -0,0 +1,64 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestLoadHashKey(t *testing.T) {
+	ref := types.NamespacedName{Namespace: "sops-operator", Name: "sops-operator-hash-key"}
+
+	t.Run("created", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).Build()
+		key, err := LoadHashKey(context.Background(), cl, ref)
+		require.NoError(t, err)
+		assert.Len(t, key, hashKeySize)
+
+		// The created key is loaded again after a restart
+		loaded, err := LoadHashKey(context.Background(), cl, ref)
+		require.NoError(t, err)
+		assert.Equal(t, key, loaded)
+	})
+
+	t.Run("existing", func(t *testing.T) {
+		secret := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: ref.Namespace},
+			Data:       map[string][]byte{"hash-key": []byte("existing")},
+		}
+		cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(secret).Build()
+		key, err := LoadHashKey(context.Background(), cl, ref)
+		require.NoError(t, err)
+		assert.Equal(t, []byte("existing"), key)
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		secret := &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: ref.Name, Namespace: ref.Namespace}}
+		cl := fake.NewClientBuilder().WithScheme(scheme.Scheme).WithObjects(secret).Build()
+		_, err := LoadHashKey(context.Background(), cl, ref)
+		assert.EqualError(t, err, `secret sops-operator/sops-operator-hash-key has no key "hash-key"`)
+	})
+}
@@ -0,0 +1,113 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"hash"
+	"sort"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// newKeyStatuses returns the status of each decrypted or failed entry of the given SopsSecret, sorted by name,
+// hashing decrypted values with the given key.
+// Entries that failed keep the hash, size, last changed time and expanded keys of their previous status.
+func newKeyStatuses(hashKey []byte, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, decrypted map[string][]byte, errs map[string]error) []craftypathgithubiov1alpha1.SopsSecretKeyStatus {
+	previous := make(map[string]craftypathgithubiov1alpha1.SopsSecretKeyStatus, len(sopsSecret.Status.Keys))
+	for _, status := range sopsSecret.Status.Keys {
+		previous[status.Name] = status
+	}
+
+	names := make([]string, 0, len(decrypted)+len(errs))
+	for name := range decrypted {
+		names = append(names, name)
+	}
+	for name := range errs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := metav1.Now()
+	statuses := make([]craftypathgithubiov1alpha1.SopsSecretKeyStatus, 0, len(names))
+	for _, name := range names {
+		last, hasPrevious := previous[name]
+		if err, failed := errs[name]; failed {
+			status := craftypathgithubiov1alpha1.SopsSecretKeyStatus{
+				Name:   name,
+				Format: entryFormat(sopsSecret, name),
+				Error:  err.Error(),
+			}
+			if hasPrevious {
+				status.Size = last.Size
+				status.Hash = last.Hash
+				status.LastChanged = last.LastChanged
+				status.ExpandedKeys = last.ExpandedKeys
+			}
+			statuses = append(statuses, status)
+			continue
+		}
+
+		status := craftypathgithubiov1alpha1.SopsSecretKeyStatus{
+			Name:        name,
+			Format:      entryFormat(sopsSecret, name),
+			Size:        int64(len(decrypted[name])),
+			Hash:        hashValue(hashKey, sopsSecret.UID, decrypted[name]),
+			LastChanged: now,
+		}
+		if hasPrevious && last.Hash == status.Hash && !last.LastChanged.IsZero() {
+			status.LastChanged = last.LastChanged
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// hashValue returns an HMAC-SHA256 of the given value keyed with the operator's hash key. The UID of the
+// object the value belongs to is included, so that equal values of different objects cannot be correlated.
+func hashValue(key []byte, uid types.UID, value []byte) string {
+	mac := hmac.New(sha256.New, key)
+	writeLengthPrefixed(mac, []byte(uid))
+	writeLengthPrefixed(mac, value)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// hashData returns an HMAC-SHA256 of the given Secret data keyed with the operator's hash key,
+// including the UID of the object the data belongs to.
+func hashData(key []byte, uid types.UID, data map[string][]byte) string {
+	mac := hmac.New(sha256.New, key)
+	writeLengthPrefixed(mac, []byte(uid))
+	for _, dataKey := range sortedDataKeys(data) {
+		// Keys and values are length-prefixed so that different data cannot produce the same input
+		writeLengthPrefixed(mac, []byte(dataKey))
+		writeLengthPrefixed(mac, data[dataKey])
+	}
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func writeLengthPrefixed(h hash.Hash, b []byte) {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(b)))
+	h.Write(length[:])
+	h.Write(b)
+}
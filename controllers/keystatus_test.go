@@ -0,0 +1,93 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+var hashKey = []byte("hash key")
+
+func TestNewKeyStatuses(t *testing.T) {
+	lastChanged := metav1.NewTime(time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC))
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{UID: "1234"},
+		Status: v1alpha1.SopsSecretStatus{
+			Keys: []v1alpha1.SopsSecretKeyStatus{
+				{Name: "unchanged.yaml", Format: "yaml", Size: 3, Hash: hashValue(hashKey, "1234", []byte("foo")), LastChanged: lastChanged},
+				{Name: "changed.json", Format: "json", Size: 3, Hash: hashValue(hashKey, "1234", []byte("foo")), LastChanged: lastChanged},
+				{Name: "broken.env", Format: "dotenv", Size: 3, Hash: hashValue(hashKey, "1234", []byte("foo")), LastChanged: lastChanged},
+			},
+		},
+	}
+	decrypted := map[string][]byte{
+		"unchanged.yaml": []byte("foo"),
+		"changed.json":   []byte("foobar"),
+		"new.bin":        []byte("baz"),
+	}
+	errs := map[string]error{
+		"broken.env": errors.New("failed to decrypt file"),
+		"other.ini":  errors.New("failed to decrypt file"),
+	}
+
+	statuses := newKeyStatuses(hashKey, sopsSecret, decrypted, errs)
+	require.Len(t, statuses, 5)
+
+	names := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		names = append(names, status.Name)
+	}
+	assert.Equal(t, []string{"broken.env", "changed.json", "new.bin", "other.ini", "unchanged.yaml"}, names)
+
+	assert.Equal(t, v1alpha1.SopsSecretKeyStatus{
+		Name: "broken.env", Format: "dotenv", Size: 3, Hash: hashValue(hashKey, "1234", []byte("foo")), LastChanged: lastChanged, Error: "failed to decrypt file",
+	}, statuses[0])
+	assert.Equal(t, "json", statuses[1].Format)
+	assert.Equal(t, int64(6), statuses[1].Size)
+	assert.Equal(t, hashValue(hashKey, "1234", []byte("foobar")), statuses[1].Hash)
+	assert.True(t, statuses[1].LastChanged.After(lastChanged.Time))
+	assert.Equal(t, "binary", statuses[2].Format)
+	assert.False(t, statuses[2].LastChanged.IsZero())
+	assert.Equal(t, v1alpha1.SopsSecretKeyStatus{Name: "other.ini", Format: "ini", Error: "failed to decrypt file"}, statuses[3])
+	assert.Equal(t, lastChanged, statuses[4].LastChanged)
+	assert.Empty(t, statuses[4].Error)
+}
+
+func TestHashValue(t *testing.T) {
+	assert.Equal(t, hashValue(hashKey, "1234", []byte("foo")), hashValue(hashKey, "1234", []byte("foo")))
+	assert.NotEqual(t, hashValue(hashKey, "1234", []byte("foo")), hashValue([]byte("other key"), "1234", []byte("foo")))
+	assert.NotEqual(t, hashValue(hashKey, "1234", []byte("foo")), hashValue(hashKey, "5678", []byte("foo")))
+	assert.NotEqual(t, hashValue(hashKey, "1234", []byte("foo")), hashValue(hashKey, "1234", []byte("bar")))
+	assert.NotEqual(t, hashValue(hashKey, "12", []byte("34foo")), hashValue(hashKey, "1234", []byte("foo")))
+	assert.Len(t, hashValue(hashKey, "1234", []byte("foo")), 64)
+}
+
+func TestHashData(t *testing.T) {
+	data := map[string][]byte{"a": []byte("bc"), "d": []byte("e")}
+	assert.Equal(t, hashData(hashKey, "1234", data), hashData(hashKey, "1234", map[string][]byte{"d": []byte("e"), "a": []byte("bc")}))
+	assert.NotEqual(t, hashData(hashKey, "1234", data), hashData([]byte("other key"), "1234", data))
+	assert.NotEqual(t, hashData(hashKey, "1234", data), hashData(hashKey, "5678", data))
+	assert.NotEqual(t, hashData(hashKey, "1234", data), hashData(hashKey, "1234", map[string][]byte{"ab": []byte("c"), "d": []byte("e")}))
+}
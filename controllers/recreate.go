@@ -0,0 +1,95 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// recreateIfNeeded deletes the given target Secret if the generated contents change fields that cannot
+// be updated, so that it is created again. The Secret is only deleted if the SopsSecret's recreate policy allows it.
+// The name of the conflicting field is returned if the Secret was deleted.
+func (r *SopsSecretReconciler) recreateIfNeeded(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, target generatedTarget, generated *generatedSecret) (string, error) {
+	existing := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: target.ref.Namespace, Name: target.ref.Name}, existing); err != nil {
+		if apierrors.IsNotFound(err) {
+			return "", nil
+		}
+		return "", fmt.Errorf("unable to get secret %s/%s: %w", target.ref.Namespace, target.ref.Name, err)
+	}
+	// Secrets not owned yet are handled by adoption
+	if !isOwnedBy(existing, sopsSecret) {
+		return "", nil
+	}
+
+	desired := existing.DeepCopy()
+	if err := r.update(ctx, desired, sopsSecret, target, generated); err != nil {
+		return "", fmt.Errorf("failed to update secret: %w", err)
+	}
+	field := immutableFieldConflict(existing, desired)
+	if field == "" {
+		return "", nil
+	}
+
+	if sopsSecret.Spec.RecreatePolicy != craftypathgithubiov1alpha1.RecreatePolicyAllow {
+		return "", &reasonError{
+			reason: reasonImmutableFieldConflict,
+			err:    fmt.Errorf("secret %s must be recreated to change its %s, which requires recreatePolicy Allow", target.ref.Name, field),
+		}
+	}
+
+	log.FromContext(ctx).Info("deleting secret for recreation", "secret", target.ref, "field", field)
+	if err := r.Delete(ctx, existing, client.Preconditions{UID: &existing.UID}); client.IgnoreNotFound(err) != nil {
+		return "", fmt.Errorf("unable to delete secret %s/%s for recreation: %w", target.ref.Namespace, target.ref.Name, err)
+	}
+	return field, nil
+}
+
+// immutableFieldConflict returns the name of the field that prevents updating the existing Secret
+// to the desired Secret, or an empty string if it can be updated.
+func immutableFieldConflict(existing, desired *corev1.Secret) string {
+	if secretTypeOrDefault(existing.Type) != secretTypeOrDefault(desired.Type) {
+		return "type"
+	}
+	if existing.Immutable != nil && *existing.Immutable {
+		if desired.Immutable == nil || !*desired.Immutable {
+			return "immutability"
+		}
+		if !equality.Semantic.DeepEqual(existing.Data, desired.Data) {
+			return "data"
+		}
+	}
+	return ""
+}
+
+// secretTypeOrDefault returns the given Secret type, or the type Secrets default to if it is empty.
+func secretTypeOrDefault(secretType corev1.SecretType) corev1.SecretType {
+	if secretType == "" {
+		return corev1.SecretTypeOpaque
+	}
+	return secretType
+}
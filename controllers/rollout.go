@@ -0,0 +1,267 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// checksumAnnotationPrefix is the prefix of the pod template annotation holding the data hash of a SopsSecret.
+// Changing it restarts the pods of a workload.
+const checksumAnnotationPrefix = "checksum.craftypath.github.io/"
+
+// workload is a Deployment, StatefulSet or DaemonSet selected for rollout.
+type workload struct {
+	kind   craftypathgithubiov1alpha1.WorkloadKind
+	object client.Object
+	// template is the pod template of object.
+	template *corev1.PodTemplateSpec
+}
+
+func (w workload) String() string {
+	return fmt.Sprintf("%s %s/%s", w.kind, w.object.GetNamespace(), w.object.GetName())
+}
+
+// rollout restarts the pods of the rollout targets of the given SopsSecret whose checksum annotation differs
+// from the SopsSecret's data hash once the data hash has changed since the last rollout. The data hash seen
+// first is recorded without restarting any pods. Restarts are rate-limited by MinRolloutInterval; if a rollout
+// is due but not yet allowed, the time after which it is allowed is returned.
+func (r *SopsSecretReconciler) rollout(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, targets []generatedTarget) (time.Duration, error) {
+	if len(sopsSecret.Spec.RolloutTargets) == 0 {
+		return 0, nil
+	}
+	if sopsSecret.Status.RolloutDataHash == "" {
+		sopsSecret.Status.RolloutDataHash = sopsSecret.Status.DataHash
+		return 0, nil
+	}
+	if sopsSecret.Status.RolloutDataHash == sopsSecret.Status.DataHash {
+		return 0, nil
+	}
+
+	workloads, err := r.rolloutWorkloads(ctx, sopsSecret, targets)
+	if err != nil {
+		return 0, err
+	}
+	annotation := checksumAnnotation(sopsSecret)
+	var outdated []workload
+	for _, w := range workloads {
+		if w.template.Annotations[annotation] != sopsSecret.Status.DataHash {
+			outdated = append(outdated, w)
+		}
+	}
+	if len(outdated) == 0 {
+		sopsSecret.Status.RolloutDataHash = sopsSecret.Status.DataHash
+		return 0, nil
+	}
+
+	if last := sopsSecret.Status.LastRolloutTime; last != nil {
+		if wait := r.MinRolloutInterval - time.Since(last.Time); wait > 0 {
+			log.FromContext(ctx).Info("delaying rollout", "wait", wait)
+			return wait, nil
+		}
+	}
+
+	restarted := make([]string, 0, len(outdated))
+	for _, w := range outdated {
+		patch := client.MergeFrom(w.object.DeepCopyObject().(client.Object))
+		annotations := make(map[string]string, len(w.template.Annotations)+1)
+		for key, value := range w.template.Annotations {
+			annotations[key] = value
+		}
+		annotations[annotation] = sopsSecret.Status.DataHash
+		w.template.Annotations = annotations
+
+		log.FromContext(ctx).Info("restarting pods", "workload", w.String())
+		if err := r.Patch(ctx, w.object, patch); err != nil {
+			return 0, fmt.Errorf("unable to restart pods of %s: %w", w, err)
+		}
+		restarted = append(restarted, w.String())
+	}
+	now := metav1.Now()
+	sopsSecret.Status.LastRolloutTime = &now
+	sopsSecret.Status.RolloutDataHash = sopsSecret.Status.DataHash
+	r.Recorder.Event(sopsSecret, "Normal", "RolloutTriggered", fmt.Sprintf("Restarted pods of %s", strings.Join(restarted, ", ")))
+	return 0, nil
+}
+
+// rolloutWorkloads returns the workloads selected by the rollout targets of the given SopsSecret.
+func (r *SopsSecretReconciler) rolloutWorkloads(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, targets []generatedTarget) ([]workload, error) {
+	var workloads []workload
+	seen := make(map[string]bool)
+	add := func(w workload) {
+		if !seen[w.String()] {
+			seen[w.String()] = true
+			workloads = append(workloads, w)
+		}
+	}
+
+	for _, target := range sopsSecret.Spec.RolloutTargets {
+		switch {
+		case target.Name != "" && target.Selector != nil:
+			return nil, fmt.Errorf("rollout target must not specify both name and selector")
+		case target.Name != "":
+			w, err := r.getWorkload(ctx, target.Kind, types.NamespacedName{Namespace: sopsSecret.Namespace, Name: target.Name})
+			if err != nil {
+				if apierrors.IsNotFound(err) {
+					log.FromContext(ctx).Info("rollout target not found", "kind", target.Kind, "name", target.Name)
+					continue
+				}
+				return nil, err
+			}
+			add(w)
+		case target.Selector != nil:
+			selector, err := metav1.LabelSelectorAsSelector(target.Selector)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rollout target selector: %w", err)
+			}
+			listed, err := r.listWorkloads(ctx, target.Kind, client.InNamespace(sopsSecret.Namespace), client.MatchingLabelsSelector{Selector: selector})
+			if err != nil {
+				return nil, err
+			}
+			for _, w := range listed {
+				add(w)
+			}
+		default:
+			for _, ref := range targets {
+				listed, err := r.listWorkloads(ctx, target.Kind, client.InNamespace(ref.ref.Namespace), client.MatchingLabelsSelector{Selector: labels.Everything()})
+				if err != nil {
+					return nil, err
+				}
+				for _, w := range listed {
+					if referencesSecret(&w.template.Spec, ref.ref.Name) {
+						add(w)
+					}
+				}
+			}
+		}
+	}
+	return workloads, nil
+}
+
+func (r *SopsSecretReconciler) getWorkload(ctx context.Context, kind craftypathgithubiov1alpha1.WorkloadKind, name types.NamespacedName) (workload, error) {
+	var w workload
+	switch kind {
+	case craftypathgithubiov1alpha1.WorkloadKindDeployment:
+		deployment := &appsv1.Deployment{}
+		w = workload{kind: kind, object: deployment, template: &deployment.Spec.Template}
+	case craftypathgithubiov1alpha1.WorkloadKindStatefulSet:
+		statefulSet := &appsv1.StatefulSet{}
+		w = workload{kind: kind, object: statefulSet, template: &statefulSet.Spec.Template}
+	case craftypathgithubiov1alpha1.WorkloadKindDaemonSet:
+		daemonSet := &appsv1.DaemonSet{}
+		w = workload{kind: kind, object: daemonSet, template: &daemonSet.Spec.Template}
+	default:
+		return w, fmt.Errorf("unsupported rollout target kind %q", kind)
+	}
+	if err := r.Get(ctx, name, w.object); err != nil {
+		return w, err
+	}
+	return w, nil
+}
+
+func (r *SopsSecretReconciler) listWorkloads(ctx context.Context, kind craftypathgithubiov1alpha1.WorkloadKind, opts ...client.ListOption) ([]workload, error) {
+	var workloads []workload
+	switch kind {
+	case craftypathgithubiov1alpha1.WorkloadKindDeployment:
+		list := &appsv1.DeploymentList{}
+		if err := r.List(ctx, list, opts...); err != nil {
+			return nil, fmt.Errorf("unable to list deployments: %w", err)
+		}
+		for i := range list.Items {
+			workloads = append(workloads, workload{kind: kind, object: &list.Items[i], template: &list.Items[i].Spec.Template})
+		}
+	case craftypathgithubiov1alpha1.WorkloadKindStatefulSet:
+		list := &appsv1.StatefulSetList{}
+		if err := r.List(ctx, list, opts...); err != nil {
+			return nil, fmt.Errorf("unable to list statefulsets: %w", err)
+		}
+		for i := range list.Items {
+			workloads = append(workloads, workload{kind: kind, object: &list.Items[i], template: &list.Items[i].Spec.Template})
+		}
+	case craftypathgithubiov1alpha1.WorkloadKindDaemonSet:
+		list := &appsv1.DaemonSetList{}
+		if err := r.List(ctx, list, opts...); err != nil {
+			return nil, fmt.Errorf("unable to list daemonsets: %w", err)
+		}
+		for i := range list.Items {
+			workloads = append(workloads, workload{kind: kind, object: &list.Items[i], template: &list.Items[i].Spec.Template})
+		}
+	default:
+		return nil, fmt.Errorf("unsupported rollout target kind %q", kind)
+	}
+	return workloads, nil
+}
+
+// referencesSecret returns whether the given pod spec references the Secret with the given name
+// in volumes, environment variables or image pull secrets.
+func referencesSecret(spec *corev1.PodSpec, name string) bool {
+	for _, volume := range spec.Volumes {
+		if volume.Secret != nil && volume.Secret.SecretName == name {
+			return true
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.Secret != nil && source.Secret.Name == name {
+					return true
+				}
+			}
+		}
+	}
+	for _, pullSecret := range spec.ImagePullSecrets {
+		if pullSecret.Name == name {
+			return true
+		}
+	}
+	containers := append(append([]corev1.Container{}, spec.InitContainers...), spec.Containers...)
+	for _, container := range containers {
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil && envFrom.SecretRef.Name == name {
+				return true
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil && env.ValueFrom.SecretKeyRef.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checksumAnnotation returns the pod template annotation holding the data hash of the given SopsSecret.
+// Its name is truncated to the maximum length of annotation names.
+func checksumAnnotation(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) string {
+	name := sopsSecret.Name
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-.")
+	}
+	return checksumAnnotationPrefix + name
+}
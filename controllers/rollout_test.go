@@ -0,0 +1,286 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func newDeployment(name string, labels map[string]string, spec corev1.PodSpec) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Labels: labels},
+		Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: spec}},
+	}
+}
+
+func envFromSecret(secretName string) corev1.PodSpec {
+	return corev1.PodSpec{
+		Containers: []corev1.Container{
+			{
+				Name:    "app",
+				EnvFrom: []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: secretName}}}},
+			},
+		},
+	}
+}
+
+func TestReconcile_Rollout(t *testing.T) {
+	tests := []struct {
+		name           string
+		rolloutTargets []v1alpha1.SopsSecretRolloutTarget
+		objs           []runtime.Object
+		wantRestarted  []string
+		wantEvent      string
+	}{
+		{
+			name:           "by name",
+			rolloutTargets: []v1alpha1.SopsSecretRolloutTarget{{Kind: v1alpha1.WorkloadKindDeployment, Name: "app"}},
+			objs: []runtime.Object{
+				newDeployment("app", nil, corev1.PodSpec{}),
+				newDeployment("other", nil, envFromSecret(name)),
+			},
+			wantRestarted: []string{"app"},
+			wantEvent:     "Normal RolloutTriggered Restarted pods of Deployment test-namespace/app",
+		},
+		{
+			name:           "by name not found",
+			rolloutTargets: []v1alpha1.SopsSecretRolloutTarget{{Kind: v1alpha1.WorkloadKindDeployment, Name: "missing"}},
+			objs:           []runtime.Object{newDeployment("app", nil, envFromSecret(name))},
+		},
+		{
+			name: "by selector",
+			rolloutTargets: []v1alpha1.SopsSecretRolloutTarget{
+				{Kind: v1alpha1.WorkloadKindDeployment, Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "test"}}},
+			},
+			objs: []runtime.Object{
+				newDeployment("app", map[string]string{"app": "test"}, corev1.PodSpec{}),
+				newDeployment("other", map[string]string{"app": "other"}, corev1.PodSpec{}),
+			},
+			wantRestarted: []string{"app"},
+			wantEvent:     "Normal RolloutTriggered Restarted pods of Deployment test-namespace/app",
+		},
+		{
+			name:           "discovered",
+			rolloutTargets: []v1alpha1.SopsSecretRolloutTarget{{Kind: v1alpha1.WorkloadKindDeployment}},
+			objs: []runtime.Object{
+				newDeployment("app", nil, envFromSecret(name)),
+				newDeployment("other", nil, envFromSecret("other")),
+				newDeployment("volume", nil, corev1.PodSpec{
+					Volumes: []corev1.Volume{{Name: "secret", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: name}}}},
+				}),
+			},
+			wantRestarted: []string{"app", "volume"},
+			wantEvent:     "Normal RolloutTriggered Restarted pods of Deployment test-namespace/app, Deployment test-namespace/volume",
+		},
+		{
+			name:           "statefulset",
+			rolloutTargets: []v1alpha1.SopsSecretRolloutTarget{{Kind: v1alpha1.WorkloadKindStatefulSet, Name: "db"}},
+			objs: []runtime.Object{
+				&appsv1.StatefulSet{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: namespace}},
+			},
+			wantEvent: "Normal RolloutTriggered Restarted pods of StatefulSet test-namespace/db",
+		},
+		{
+			name: "name and selector",
+			rolloutTargets: []v1alpha1.SopsSecretRolloutTarget{
+				{Kind: v1alpha1.WorkloadKindDeployment, Name: "app", Selector: &metav1.LabelSelector{}},
+			},
+			objs:      []runtime.Object{newDeployment("app", nil, corev1.PodSpec{})},
+			wantEvent: "Warning RolloutFailed Rollout target must not specify both name and selector",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec: v1alpha1.SopsSecretSpec{
+					StringData:     map[string]string{"test.yaml": "encrypted"},
+					RolloutTargets: tt.rolloutTargets,
+				},
+				Status: v1alpha1.SopsSecretStatus{RolloutDataHash: "previous"},
+			}
+			recorder := record.NewFakeRecorder(2)
+			r := newSopsSecretReconciler(s, recorder, append(tt.objs, sopsSecret)...)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			if tt.wantEvent != "" {
+				assert.Equal(t, tt.wantEvent, <-recorder.Events)
+			}
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+			assert.True(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+			deployments := &appsv1.DeploymentList{}
+			require.NoError(t, r.List(context.Background(), deployments))
+			var restarted []string
+			for _, deployment := range deployments.Items {
+				if deployment.Spec.Template.Annotations["checksum.craftypath.github.io/test-secret"] == sopsSecret.Status.DataHash {
+					restarted = append(restarted, deployment.Name)
+				}
+			}
+			assert.Equal(t, tt.wantRestarted, restarted)
+		})
+	}
+}
+
+func TestReconcile_RolloutOnDataChange(t *testing.T) {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData:     map[string]string{"test.yaml": "encrypted"},
+			RolloutTargets: []v1alpha1.SopsSecretRolloutTarget{{Kind: v1alpha1.WorkloadKindDeployment}},
+		},
+	}
+	recorder := record.NewFakeRecorder(2)
+	decryptor := &FakeDecryptor{}
+	r := newSopsSecretReconciler(s, recorder, sopsSecret, newDeployment("app", nil, envFromSecret(name)))
+	r.Decryptor = decryptor
+	r.MinRolloutInterval = time.Hour
+
+	// the data hash seen first does not restart the pods
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	assert.Nil(t, sopsSecret.Status.LastRolloutTime)
+	assert.Equal(t, sopsSecret.Status.DataHash, sopsSecret.Status.RolloutDataHash)
+
+	// unchanged data does not restart the pods
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+
+	// changed data restarts the pods
+	decryptor.decrypted = "changed"
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal RolloutTriggered Restarted pods of Deployment test-namespace/app", <-recorder.Events)
+	assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	require.NotNil(t, sopsSecret.Status.LastRolloutTime)
+	firstHash := sopsSecret.Status.DataHash
+
+	// changed data within the minimum interval delays the rollout
+	decryptor.decrypted = "changed again"
+	res, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+	assert.Greater(t, int64(res.RequeueAfter), int64(59*time.Minute))
+	deployment := &appsv1.Deployment{}
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	require.NoError(t, r.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "app"}, deployment))
+	assert.Equal(t, firstHash, deployment.Spec.Template.Annotations["checksum.craftypath.github.io/test-secret"])
+
+	// once the interval has passed, the pods are restarted
+	r.MinRolloutInterval = 0
+	res, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.Equal(t, "Normal RolloutTriggered Restarted pods of Deployment test-namespace/app", <-recorder.Events)
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	require.NoError(t, r.Get(context.Background(), client.ObjectKey{Namespace: namespace, Name: "app"}, deployment))
+	assert.NotEqual(t, firstHash, sopsSecret.Status.DataHash)
+	assert.Equal(t, sopsSecret.Status.DataHash, deployment.Spec.Template.Annotations["checksum.craftypath.github.io/test-secret"])
+}
+
+func TestReferencesSecret(t *testing.T) {
+	tests := []struct {
+		name string
+		spec corev1.PodSpec
+		want bool
+	}{
+		{
+			name: "none",
+			spec: envFromSecret("other"),
+		},
+		{
+			name: "env from",
+			spec: envFromSecret(name),
+			want: true,
+		},
+		{
+			name: "env of init container",
+			spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{
+					{
+						Name: "init",
+						Env: []corev1.EnvVar{
+							{
+								Name: "PASSWORD",
+								ValueFrom: &corev1.EnvVarSource{
+									SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: name}, Key: "password"},
+								},
+							},
+						},
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "projected volume",
+			spec: corev1.PodSpec{
+				Volumes: []corev1.Volume{
+					{
+						Name: "projected",
+						VolumeSource: corev1.VolumeSource{
+							Projected: &corev1.ProjectedVolumeSource{
+								Sources: []corev1.VolumeProjection{{Secret: &corev1.SecretProjection{LocalObjectReference: corev1.LocalObjectReference{Name: name}}}},
+							},
+						},
+					},
+				},
+			},
+			want: true,
+		},
+		{
+			name: "image pull secret",
+			spec: corev1.PodSpec{ImagePullSecrets: []corev1.LocalObjectReference{{Name: name}}},
+			want: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, referencesSecret(&tt.spec, name))
+		})
+	}
+}
@@ -0,0 +1,239 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// SopsConfigMapReconciler reconciles a SopsConfigMap object
+type SopsConfigMapReconciler struct {
+	client.Client
+	Scheme    *runtime.Scheme
+	Recorder  record.EventRecorder
+	Decryptor Decryptor
+	// HashKey is the key the hashes in the status are keyed with, see LoadHashKey.
+	HashKey []byte
+
+	backoff errorBackoff
+}
+
+//+kubebuilder:rbac:groups=craftypath.github.io,resources=sopsconfigmaps,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=craftypath.github.io,resources=sopsconfigmaps/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch;create;update;patch;delete
+
+func (r *SopsConfigMapReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.FromContext(ctx)
+	reqLogger.Info("reconciling SopsConfigMap")
+
+	instance := &craftypathgithubiov1alpha1.SopsConfigMap{}
+	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Objects deleted while failing are not retried anymore
+			r.backoff.reset(req.NamespacedName)
+		}
+		return reconcile.Result{}, client.IgnoreNotFound(err)
+	}
+	// The generated ConfigMap is garbage collected via its owner reference
+	if !instance.DeletionTimestamp.IsZero() {
+		return reconcile.Result{}, nil
+	}
+
+	g := &generator{Reader: r.Client, Decryptor: r.Decryptor, HashKey: r.HashKey}
+	generated, keyStatuses, err := g.generate(ctx, sopsSecretFor(instance), r.previousData(ctx, instance))
+	if keyStatuses != nil {
+		instance.Status.Keys = keyStatuses
+	}
+	if err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, fmt.Errorf("failed to update configmap: %w", err))
+	}
+	if len(generated.failed) > 0 {
+		msg := fmt.Sprintf("failed to decrypt keys %s, keeping their last good values", strings.Join(generated.failed, ", "))
+		r.Recorder.Event(instance, "Warning", reasonDecryptionFailed, capitalizeFirst(msg))
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, metav1.ConditionFalse, reasonDecryptionFailed, msg)
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDegraded, metav1.ConditionTrue, reasonDecryptionFailed, msg)
+	} else {
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, metav1.ConditionTrue, reasonReconciled, "Data decrypted successfully")
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDegraded, metav1.ConditionFalse, reasonReconciled, "All keys decrypted successfully")
+	}
+	instance.Status.DataHash = hashData(r.HashKey, instance.UID, generated.data)
+
+	opResult, err := r.syncConfigMap(ctx, instance, generated)
+	if err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeConfigMapSynced, err)
+	}
+
+	result, err := r.manageSuccess(ctx, instance, opResult)
+	if err == nil && len(generated.failed) > 0 && result.RequeueAfter == 0 {
+		// Entries that could not be decrypted are retried periodically
+		result.RequeueAfter = degradedRetryInterval
+	}
+	return result, err
+}
+
+// sopsSecretFor returns a SopsSecret with the entries, options and key statuses of the given SopsConfigMap.
+func sopsSecretFor(sopsConfigMap *craftypathgithubiov1alpha1.SopsConfigMap) *craftypathgithubiov1alpha1.SopsSecret {
+	return &craftypathgithubiov1alpha1.SopsSecret{
+		ObjectMeta: sopsConfigMap.ObjectMeta,
+		Spec: craftypathgithubiov1alpha1.SopsSecretSpec{
+			Metadata:      sopsConfigMap.Spec.Metadata,
+			StringData:    sopsConfigMap.Spec.StringData,
+			Data:          sopsConfigMap.Spec.Data,
+			Options:       sopsConfigMap.Spec.Options,
+			Template:      sopsConfigMap.Spec.Template,
+			FailurePolicy: sopsConfigMap.Spec.FailurePolicy,
+			Decryption:    sopsConfigMap.Spec.Decryption,
+		},
+		Status: craftypathgithubiov1alpha1.SopsSecretStatus{
+			Keys: sopsConfigMap.Status.Keys,
+		},
+	}
+}
+
+// previousData returns the data of the ConfigMap previously generated from the given SopsConfigMap, if any.
+func (r *SopsConfigMapReconciler) previousData(ctx context.Context, sopsConfigMap *craftypathgithubiov1alpha1.SopsConfigMap) map[string][]byte {
+	configMap := &corev1.ConfigMap{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: sopsConfigMap.Namespace, Name: sopsConfigMap.Name}, configMap); err != nil {
+		return nil
+	}
+	if !metav1.IsControlledBy(configMap, sopsConfigMap) {
+		return nil
+	}
+	previous := make(map[string][]byte, len(configMap.Data)+len(configMap.BinaryData))
+	for key, value := range configMap.Data {
+		previous[key] = []byte(value)
+	}
+	for key, value := range configMap.BinaryData {
+		previous[key] = value
+	}
+	return previous
+}
+
+// syncConfigMap creates or updates the ConfigMap generated from the given SopsConfigMap.
+func (r *SopsConfigMapReconciler) syncConfigMap(ctx context.Context, sopsConfigMap *craftypathgithubiov1alpha1.SopsConfigMap, generated *generatedSecret) (controllerutil.OperationResult, error) {
+	configMap := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      sopsConfigMap.Name,
+			Namespace: sopsConfigMap.Namespace,
+		},
+	}
+
+	return ctrl.CreateOrUpdate(ctx, r.Client, configMap, func() error {
+		if !configMap.CreationTimestamp.IsZero() && !metav1.IsControlledBy(configMap, sopsConfigMap) {
+			return fmt.Errorf("configmap already exists and not owned by sops-operator")
+		}
+
+		configMap.Annotations = generated.annotations
+		configMap.Labels = generated.labels
+		configMap.Data, configMap.BinaryData = configMapData(generated.data)
+		if err := ctrl.SetControllerReference(sopsConfigMap, configMap, r.Scheme); err != nil {
+			return fmt.Errorf("unable to set ownerReference: %w", err)
+		}
+		return nil
+	})
+}
+
+// configMapData splits the given data into the data and binary data of a ConfigMap.
+// Values that are valid UTF-8 are stored as data, all other values as binary data.
+func configMapData(data map[string][]byte) (map[string]string, map[string][]byte) {
+	var stringData map[string]string
+	var binaryData map[string][]byte
+	for key, value := range data {
+		if utf8.Valid(value) {
+			if stringData == nil {
+				stringData = make(map[string]string)
+			}
+			stringData[key] = string(value)
+			continue
+		}
+		if binaryData == nil {
+			binaryData = make(map[string][]byte)
+		}
+		binaryData[key] = value
+	}
+	return stringData, binaryData
+}
+
+// manageError records the given error as the reason of the given condition being false and requeues the SopsConfigMap.
+func (r *SopsConfigMapReconciler) manageError(ctx context.Context, instance *craftypathgithubiov1alpha1.SopsConfigMap, conditionType string, issue error) (reconcile.Result, error) {
+	return manageError(ctx, r.Client, r.Recorder, &r.backoff, instance, conditionType, issue)
+}
+
+func (r *SopsConfigMapReconciler) manageSuccess(ctx context.Context, instance *craftypathgithubiov1alpha1.SopsConfigMap, result controllerutil.OperationResult) (reconcile.Result, error) {
+	if res, ok := manageSuccess(ctx, r.Client, r.Recorder, &r.backoff, instance, craftypathgithubiov1alpha1.ConditionTypeConfigMapSynced, "ConfigMap synced successfully"); !ok {
+		return res, nil
+	}
+
+	if result != controllerutil.OperationResultNone {
+		opResult := capitalizeFirst(string(result))
+		msg := fmt.Sprintf("%s configmap: %s", opResult, instance.Name)
+		log.FromContext(ctx).Info("status updated successfully: " + msg)
+		r.Recorder.Event(instance, "Normal", opResult, msg)
+	}
+	return reconcile.Result{}, nil
+}
+
+// findSopsConfigMapsForKeySecret returns requests for all SopsConfigMaps referencing the given Secret as key ref.
+func (r *SopsConfigMapReconciler) findSopsConfigMapsForKeySecret(obj client.Object) []reconcile.Request {
+	sopsConfigMaps := &craftypathgithubiov1alpha1.SopsConfigMapList{}
+	if err := r.List(context.Background(), sopsConfigMaps, client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{keyRefIndexKey: obj.GetName()}); err != nil {
+		log.Log.Error(err, "unable to list SopsConfigMaps", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(sopsConfigMaps.Items))
+	for _, sopsConfigMap := range sopsConfigMaps.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: sopsConfigMap.Namespace, Name: sopsConfigMap.Name},
+		})
+	}
+	return requests
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *SopsConfigMapReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &craftypathgithubiov1alpha1.SopsConfigMap{}, keyRefIndexKey, func(obj client.Object) []string {
+		return keyRefIndexValues(obj.(*craftypathgithubiov1alpha1.SopsConfigMap).Spec.Decryption)
+	}); err != nil {
+		return err
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&craftypathgithubiov1alpha1.SopsConfigMap{}).
+		Owns(&corev1.ConfigMap{}).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findSopsConfigMapsForKeySecret)).
+		Complete(r)
+}
@@ -0,0 +1,224 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func newSopsConfigMapReconciler(recorder *record.FakeRecorder, decryptor *FakeDecryptor, objs ...runtime.Object) *SopsConfigMapReconciler {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+	return &SopsConfigMapReconciler{
+		Client:    cl,
+		Scheme:    s,
+		Recorder:  recorder,
+		Decryptor: decryptor,
+	}
+}
+
+func TestReconcileSopsConfigMap(t *testing.T) {
+	sopsConfigMap := &v1alpha1.SopsConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1alpha1.SopsConfigMapSpec{
+			Metadata:   v1alpha1.SopsSecretObjectMeta{Labels: map[string]string{"mylabel": "foo"}},
+			StringData: map[string]string{"hosts.yaml": "encrypted"},
+			Data:       map[string][]byte{"cert.der": []byte("encrypted")},
+			Template:   map[string]string{"url": `https://{{ index .Data "hosts.yaml" "api" }}`},
+		},
+	}
+	decryptor := &FakeDecryptor{files: map[string]string{
+		"hosts.yaml": "api: api.internal\n",
+		"cert.der":   "\xff\xfe",
+	}}
+	recorder := record.NewFakeRecorder(2)
+	r := newSopsConfigMapReconciler(recorder, decryptor, sopsConfigMap)
+
+	res, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.False(t, res.Requeue)
+	assert.Equal(t, "Normal Created Created configmap: test-secret", <-recorder.Events)
+
+	configMap := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, configMap))
+	assert.Equal(t, map[string]string{"hosts.yaml": "api: api.internal\n", "url": "https://api.internal"}, configMap.Data)
+	assert.Equal(t, map[string][]byte{"cert.der": []byte("\xff\xfe")}, configMap.BinaryData)
+	assert.Equal(t, map[string]string{"mylabel": "foo"}, configMap.Labels)
+	assert.True(t, metav1.IsControlledBy(configMap, sopsConfigMap))
+
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsConfigMap))
+	assert.True(t, meta.IsStatusConditionTrue(sopsConfigMap.Status.Conditions, v1alpha1.ConditionTypeReady))
+	assert.True(t, meta.IsStatusConditionTrue(sopsConfigMap.Status.Conditions, v1alpha1.ConditionTypeConfigMapSynced))
+	assert.False(t, meta.IsStatusConditionTrue(sopsConfigMap.Status.Conditions, v1alpha1.ConditionTypeDegraded))
+	require.Len(t, sopsConfigMap.Status.Keys, 2)
+	assert.Equal(t, "binary", sopsConfigMap.Status.Keys[0].Format)
+	assert.Equal(t, "yaml", sopsConfigMap.Status.Keys[1].Format)
+	assert.NotEmpty(t, sopsConfigMap.Status.DataHash)
+
+	// unchanged data does not update the ConfigMap
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+
+	decryptor.files["hosts.yaml"] = "api: api2.internal\n"
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Updated Updated configmap: test-secret", <-recorder.Events)
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, configMap))
+	assert.Equal(t, "https://api2.internal", configMap.Data["url"])
+}
+
+func TestReconcileSopsConfigMap_Errors(t *testing.T) {
+	tests := []struct {
+		name          string
+		spec          v1alpha1.SopsConfigMapSpec
+		objs          []runtime.Object
+		decryptor     *FakeDecryptor
+		wantCondition string
+		wantEvent     string
+	}{
+		{
+			name:          "decryption failed",
+			spec:          v1alpha1.SopsConfigMapSpec{StringData: map[string]string{"hosts.yaml": "encrypted"}},
+			decryptor:     &FakeDecryptor{err: errors.New("failed to decrypt file")},
+			wantCondition: v1alpha1.ConditionTypeDecrypted,
+			wantEvent:     "Warning DecryptionFailed Failed to update configmap: failed to decrypt file",
+		},
+		{
+			name: "key secret not found",
+			spec: v1alpha1.SopsConfigMapSpec{
+				StringData: map[string]string{"hosts.yaml": "encrypted"},
+				Decryption: &v1alpha1.SopsSecretDecryption{KeyRef: &corev1.LocalObjectReference{Name: "sops-keys"}},
+			},
+			decryptor:     &FakeDecryptor{},
+			wantCondition: v1alpha1.ConditionTypeDecrypted,
+			wantEvent:     `Warning KeyRefNotFound Failed to update configmap: key secret "sops-keys" not found`,
+		},
+		{
+			name: "existing configmap not owned",
+			spec: v1alpha1.SopsConfigMapSpec{StringData: map[string]string{"hosts.yaml": "encrypted"}},
+			objs: []runtime.Object{
+				&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, CreationTimestamp: metav1.Now()}},
+			},
+			decryptor:     &FakeDecryptor{},
+			wantCondition: v1alpha1.ConditionTypeConfigMapSynced,
+			wantEvent:     "Warning ProcessingError Configmap already exists and not owned by sops-operator",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsConfigMap := &v1alpha1.SopsConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec:       tt.spec,
+			}
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsConfigMapReconciler(recorder, tt.decryptor, append(tt.objs, sopsConfigMap)...)
+
+			res, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.True(t, res.Requeue)
+			assert.Equal(t, tt.wantEvent, <-recorder.Events)
+
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsConfigMap))
+			assert.False(t, meta.IsStatusConditionTrue(sopsConfigMap.Status.Conditions, v1alpha1.ConditionTypeReady))
+			condition := meta.FindStatusCondition(sopsConfigMap.Status.Conditions, tt.wantCondition)
+			require.NotNil(t, condition)
+			assert.Equal(t, metav1.ConditionFalse, condition.Status)
+		})
+	}
+}
+
+func TestReconcileSopsConfigMap_BestEffort(t *testing.T) {
+	sopsConfigMap := &v1alpha1.SopsConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Spec: v1alpha1.SopsConfigMapSpec{
+			StringData:    map[string]string{"hosts.yaml": "encrypted", "other.yaml": "encrypted"},
+			FailurePolicy: v1alpha1.FailurePolicyBestEffort,
+		},
+	}
+	decryptor := &FakeDecryptor{}
+	recorder := record.NewFakeRecorder(2)
+	r := newSopsConfigMapReconciler(recorder, decryptor, sopsConfigMap)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created configmap: test-secret", <-recorder.Events)
+
+	decryptor.errs = map[string]error{"hosts.yaml": errors.New("failed to decrypt file")}
+	decryptor.decrypted = "changed"
+	res, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, degradedRetryInterval, res.RequeueAfter)
+	assert.Equal(t, "Warning DecryptionFailed Failed to decrypt keys hosts.yaml, keeping their last good values", <-recorder.Events)
+	assert.Equal(t, "Normal Updated Updated configmap: test-secret", <-recorder.Events)
+
+	configMap := &corev1.ConfigMap{}
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, configMap))
+	assert.Equal(t, map[string]string{"hosts.yaml": "unencrypted", "other.yaml": "changed"}, configMap.Data)
+
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsConfigMap))
+	assert.True(t, meta.IsStatusConditionTrue(sopsConfigMap.Status.Conditions, v1alpha1.ConditionTypeReady))
+	assert.True(t, meta.IsStatusConditionTrue(sopsConfigMap.Status.Conditions, v1alpha1.ConditionTypeDegraded))
+}
+
+func TestConfigMapData(t *testing.T) {
+	tests := []struct {
+		name           string
+		data           map[string][]byte
+		wantData       map[string]string
+		wantBinaryData map[string][]byte
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:     "text",
+			data:     map[string][]byte{"config.yaml": []byte("foo: bär\n")},
+			wantData: map[string]string{"config.yaml": "foo: bär\n"},
+		},
+		{
+			name:           "mixed",
+			data:           map[string][]byte{"config.yaml": []byte("foo: bar\n"), "cert.der": {0x30, 0x82, 0xff}},
+			wantData:       map[string]string{"config.yaml": "foo: bar\n"},
+			wantBinaryData: map[string][]byte{"cert.der": {0x30, 0x82, 0xff}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, binaryData := configMapData(tt.data)
+			assert.Equal(t, tt.wantData, data)
+			assert.Equal(t, tt.wantBinaryData, binaryData)
+		})
+	}
+}
@@ -18,26 +18,77 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"math"
+	"net/http"
+	"strings"
 	"time"
 	"unicode"
 
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	"sigs.k8s.io/yaml"
 
 	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
 )
 
+const (
+	reasonProcessingError  = "ProcessingError"
+	reasonDecryptionFailed = "DecryptionFailed"
+	reasonReconciled       = "Reconciled"
+	reasonKeyRefNotFound   = "KeyRefNotFound"
+	reasonSourceNotFound   = "SourceNotFound"
+	reasonArtifactFailed   = "ArtifactFailed"
+	reasonTargetNotAllowed = "TargetNotAllowed"
+	reasonDriftDetected    = "DriftDetected"
+	reasonDriftCorrected   = "DriftCorrected"
+	// reasonImmutableFieldConflict is reported if a generated Secret must be recreated but the recreate policy forbids it.
+	reasonImmutableFieldConflict = "ImmutableFieldConflict"
+)
+
+// degradedRetryInterval is the interval at which SopsSecrets with entries that could not be decrypted are retried.
+const degradedRetryInterval = time.Minute
+
+// rolloutRetryInterval is the interval at which failed rollouts of workloads are retried.
+const rolloutRetryInterval = 30 * time.Second
+
+// refreshJitter is the maximum factor by which refresh intervals are extended at random.
+const refreshJitter = 0.1
+
 type Decryptor interface {
-	Decrypt(fileName string, encrypted string) ([]byte, error)
+	Decrypt(fileName string, encrypted string, keys *sops.Keys) ([]byte, error)
+}
+
+// reasonError is an error with a machine-readable reason that is reported in events and status.
+type reasonError struct {
+	reason string
+	err    error
+}
+
+func (e *reasonError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reasonError) Unwrap() error {
+	return e.err
 }
 
 // SopsSecretReconciler reconciles a SopsSecret object
@@ -46,12 +97,29 @@ type SopsSecretReconciler struct {
 	Scheme    *runtime.Scheme
 	Recorder  record.EventRecorder
 	Decryptor Decryptor
+	// MinRolloutInterval is the minimum time between two rollouts of the workloads of a SopsSecret.
+	MinRolloutInterval time.Duration
+	// HTTPClient downloads the artifacts of Flux sources. Defaults to a client with a timeout of one minute.
+	HTTPClient *http.Client
+	// RefreshInterval is the interval at which SopsSecrets without a refresh interval of their own are
+	// decrypted again after successful reconciliations. Zero disables periodic refreshes.
+	RefreshInterval time.Duration
+	// HashKey is the key the hashes in the status are keyed with, see LoadHashKey.
+	HashKey []byte
+
+	backoff errorBackoff
+	// fluxSources are the versions Flux sources are read in by kind, see fluxSourceVersions.
+	fluxSources map[craftypathgithubiov1alpha1.SourceKind]schema.GroupVersionKind
 }
 
 //+kubebuilder:rbac:groups=craftypath.github.io,resources=sopssecrets,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=craftypath.github.io,resources=sopssecrets/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=craftypath.github.io,resources=sopssecrets/finalizers,verbs=update
 //+kubebuilder:rbac:groups="",resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=apps,resources=deployments;statefulsets;daemonsets,verbs=get;list;watch;patch
+//+kubebuilder:rbac:groups="",resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=source.toolkit.fluxcd.io,resources=gitrepositories;buckets;ocirepositories,verbs=get;list;watch
 //+kubebuilder:rbac:groups=coordination.k8s.io,resources=leases,verbs=get;list;create;update
 
 func (r *SopsSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
@@ -61,129 +129,574 @@ func (r *SopsSecretReconciler) Reconcile(ctx context.Context, req ctrl.Request)
 	// Fetch the SopsSecret instance
 	instance := &craftypathgithubiov1alpha1.SopsSecret{}
 	if err := r.Get(ctx, req.NamespacedName, instance); err != nil {
+		if apierrors.IsNotFound(err) {
+			// Objects deleted while failing are not retried anymore
+			r.backoff.reset(req.NamespacedName)
+		}
 		return reconcile.Result{}, client.IgnoreNotFound(err)
 	}
 
+	if !instance.DeletionTimestamp.IsZero() {
+		return r.finalize(ctx, instance)
+	}
+
+	targets := targetsFor(instance)
+	if err := r.validateTargets(ctx, instance, targets); err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, err)
+	}
+	// Generated Secrets are deleted or orphaned by the finalizer according to the deletion policy,
+	// unless the garbage collector deletes them through their owner reference
+	if needsFinalizer(instance, targets) {
+		if !controllerutil.ContainsFinalizer(instance, finalizerName) {
+			controllerutil.AddFinalizer(instance, finalizerName)
+			if err := r.Update(ctx, instance); err != nil {
+				return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, fmt.Errorf("unable to add finalizer: %w", err))
+			}
+		}
+	} else if controllerutil.ContainsFinalizer(instance, finalizerName) {
+		controllerutil.RemoveFinalizer(instance, finalizerName)
+		if err := r.Update(ctx, instance); err != nil {
+			return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, fmt.Errorf("unable to remove finalizer: %w", err))
+		}
+	}
+
+	g := &generator{Reader: r.Client, Decryptor: r.Decryptor, HTTPClient: r.HTTPClient, HashKey: r.HashKey, FluxSources: r.fluxSources}
+	generated, keyStatuses, err := g.generate(ctx, instance, r.previousData(ctx, targets))
+	if keyStatuses != nil {
+		instance.Status.Keys = keyStatuses
+	}
+	if err != nil {
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, fmt.Errorf("failed to update secret: %w", err))
+	}
+	if len(generated.failed) > 0 {
+		msg := fmt.Sprintf("failed to decrypt keys %s, keeping their last good values", strings.Join(generated.failed, ", "))
+		r.Recorder.Event(instance, "Warning", reasonDecryptionFailed, capitalizeFirst(msg))
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, metav1.ConditionFalse, reasonDecryptionFailed, msg)
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDegraded, metav1.ConditionTrue, reasonDecryptionFailed, msg)
+	} else {
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDecrypted, metav1.ConditionTrue, reasonReconciled, "Data decrypted successfully")
+		setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDegraded, metav1.ConditionFalse, reasonReconciled, "All keys decrypted successfully")
+	}
+	// Generated Secrets that differ although the generated data did not change since all of them were last synced
+	// have drifted. Their labels are only compared as long as the spec they are generated from did not change either.
+	dataHash := hashData(r.HashKey, instance.UID, generated.data)
+	checkDrift := driftChecks{data: instance.Status.DataHash == dataHash}
+	checkDrift.labels = checkDrift.data && instance.Status.ObservedGeneration == instance.Generation
+
+	results := make([]controllerutil.OperationResult, len(targets))
+	statuses := make([]craftypathgithubiov1alpha1.SopsSecretTargetStatus, len(targets))
+	var syncErr error
+	var drifts []string
+	for i, target := range targets {
+		statuses[i] = craftypathgithubiov1alpha1.SopsSecretTargetStatus{
+			Name:      target.ref.Name,
+			Namespace: target.ref.Namespace,
+			Status:    "Success",
+		}
+		var drift *secretDrift
+		results[i], drift, err = r.syncTarget(ctx, instance, target, generated, checkDrift)
+		if drift != nil {
+			drifts = append(drifts, drift.String())
+		}
+		if err != nil {
+			statuses[i].Status = "Failure"
+			statuses[i].Message = err.Error()
+			if syncErr == nil {
+				syncErr = err
+			}
+		}
+	}
+
+	if syncErr != nil {
+		// Previous targets are kept until all targets are synced in order to allow
+		// consumers to switch over, so they remain recorded in the status
+		if len(instance.Spec.Targets) > 0 {
+			instance.Status.Targets = append(statuses, removedTargetStatuses(instance, targets)...)
+		}
+		return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, syncErr)
+	}
+	// The hash is only recorded once all targets have been synced, so that targets a failed sync did not
+	// update are not mistaken for drifted ones
+	instance.Status.DataHash = dataHash
+
+	if instance.Spec.DriftPolicy == craftypathgithubiov1alpha1.DriftPolicyReport {
+		if len(drifts) > 0 {
+			setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDrifted, metav1.ConditionTrue, reasonDriftDetected, strings.Join(drifts, "; "))
+		} else {
+			setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeDrifted, metav1.ConditionFalse, reasonReconciled, "Secrets match the generated data")
+		}
+	} else {
+		meta.RemoveStatusCondition(&instance.Status.Conditions, craftypathgithubiov1alpha1.ConditionTypeDrifted)
+	}
+
+	cleanup := r.cleanupFor(instance)
+	for _, previous := range previousTargetsFor(instance) {
+		if containsTarget(targets, previous) {
+			continue
+		}
+		if err := cleanup(ctx, instance, previous); err != nil {
+			return r.manageError(ctx, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, err)
+		}
+	}
+
+	// Rollout failures do not affect the generated Secrets, so they are only reported in events
+	rolloutAfter, rolloutErr := r.rollout(ctx, instance, targets)
+	if rolloutErr != nil {
+		log.FromContext(ctx).Error(rolloutErr, "unable to roll out workloads")
+		r.Recorder.Event(instance, "Warning", "RolloutFailed", capitalizeFirst(rolloutErr.Error()))
+		rolloutAfter = rolloutRetryInterval
+	}
+
+	result, err := r.manageSuccess(ctx, instance, targets, statuses, results)
+	if err == nil && len(generated.failed) > 0 && result.RequeueAfter == 0 {
+		// Entries that could not be decrypted are retried periodically
+		result.RequeueAfter = degradedRetryInterval
+	}
+	if err == nil && rolloutAfter > 0 && (result.RequeueAfter == 0 || rolloutAfter < result.RequeueAfter) {
+		result.RequeueAfter = rolloutAfter
+	}
+	// The data is decrypted again periodically to detect keys that are no longer usable
+	if refreshAfter := r.refreshAfter(instance); err == nil && refreshAfter > 0 && (result.RequeueAfter == 0 || refreshAfter < result.RequeueAfter) {
+		result.RequeueAfter = refreshAfter
+	}
+	return result, err
+}
+
+// refreshAfter returns the time after which the given SopsSecret is decrypted again, or zero if it is not
+// refreshed periodically. The refresh interval is jittered to spread the load of many SopsSecrets.
+func (r *SopsSecretReconciler) refreshAfter(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) time.Duration {
+	interval := r.RefreshInterval
+	if sopsSecret.Spec.RefreshInterval != nil {
+		interval = sopsSecret.Spec.RefreshInterval.Duration
+	}
+	if interval <= 0 {
+		return 0
+	}
+	return wait.Jitter(interval, refreshJitter)
+}
+
+// syncTarget creates or updates the given target Secret with the generated contents. Differences of the existing
+// Secret selected by checkDrift are handled according to the drift policy and returned as drift.
+func (r *SopsSecretReconciler) syncTarget(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, target generatedTarget, generated *generatedSecret, checkDrift driftChecks) (controllerutil.OperationResult, *secretDrift, error) {
 	secret := &corev1.Secret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:      instance.Name,
-			Namespace: instance.Namespace,
+			Name:      target.ref.Name,
+			Namespace: target.ref.Namespace,
 		},
 	}
 
+	recreatedField, err := r.recreateIfNeeded(ctx, sopsSecret, target, generated)
+	if err != nil {
+		return controllerutil.OperationResultNone, nil, err
+	}
+
+	adopted := false
+	var drift *secretDrift
 	result, err := ctrl.CreateOrUpdate(ctx, r.Client, secret, func() error {
+		existing := secret.DeepCopy()
 		if !secret.CreationTimestamp.IsZero() {
-			if !metav1.IsControlledBy(secret, instance) {
-				return fmt.Errorf("secret already exists and not owned by sops-operator")
+			if !isOwnedBy(secret, sopsSecret) {
+				if err := r.adopt(ctx, secret, sopsSecret); err != nil {
+					return err
+				}
+				adopted = true
 			}
 		}
-		if err := r.update(ctx, secret, instance); err != nil {
+		previousOwner := secret.Annotations[previousOwnerAnnotation]
+		if err := r.update(ctx, secret, sopsSecret, target, generated); err != nil {
 			return fmt.Errorf("failed to update secret: %w", err)
 		}
+		// The previous owner of adopted Secrets is kept for reference
+		if previousOwner != "" {
+			secret.Annotations = mergeStringMaps(secret.Annotations, map[string]string{previousOwnerAnnotation: previousOwner})
+		}
+		if checkDrift.data && !adopted && !existing.CreationTimestamp.IsZero() {
+			drift = detectDrift(existing, secret, checkDrift)
+			if drift != nil && !correctsDrift(sopsSecret) {
+				// Drifted Secrets are kept as they are
+				existing.DeepCopyInto(secret)
+			}
+		}
 		return nil
 	})
-	if err != nil {
-		return r.manageError(ctx, instance, err)
+	if err == nil && recreatedField != "" {
+		r.Recorder.Event(sopsSecret, "Normal", "Recreated", fmt.Sprintf("Recreated secret %s to change its %s", target.ref.Name, recreatedField))
+	}
+	if err == nil && adopted {
+		r.Recorder.Event(sopsSecret, "Normal", "Adopted", fmt.Sprintf("Adopted secret: %s", target.ref.Name))
+	}
+	if err == nil && drift != nil && correctsDrift(sopsSecret) {
+		r.Recorder.Event(sopsSecret, "Warning", reasonDriftCorrected, "Corrected drift: "+drift.String())
+	}
+	return result, drift, err
+}
+
+// correctsDrift returns whether drifted Secrets generated from the given SopsSecret are overwritten.
+func correctsDrift(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) bool {
+	return sopsSecret.Spec.DriftPolicy == "" || sopsSecret.Spec.DriftPolicy == craftypathgithubiov1alpha1.DriftPolicyCorrect
+}
+
+// generatedSecret holds the contents of the Secrets generated from a SopsSecret.
+type generatedSecret struct {
+	annotations map[string]string
+	labels      map[string]string
+	secretType  corev1.SecretType
+	data        map[string][]byte
+	// failed holds the entries that could not be decrypted and whose last good values are kept.
+	failed []string
+}
+
+// update applies the generated contents to the given target Secret.
+func (r *SopsSecretReconciler) update(ctx context.Context, secret *corev1.Secret, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, target generatedTarget, generated *generatedSecret) error {
+	logger := log.FromContext(ctx)
+	logger.Info("handling Secret update", "secret", target.ref)
+
+	annotations := generated.annotations
+	labels := generated.labels
+	secretType := generated.secretType
+	data := generated.data
+	if target.spec != nil {
+		annotations = mergeStringMaps(annotations, target.spec.Metadata.Annotations)
+		labels = mergeStringMaps(labels, target.spec.Metadata.Labels)
+		if target.spec.Type != "" {
+			secretType = target.spec.Type
+		}
+		if len(target.spec.Keys) > 0 {
+			selected, err := selectKeys(data, target.spec.Keys)
+			if err != nil {
+				return err
+			}
+			data = selected
+		}
 	}
 
-	return r.manageSuccess(ctx, instance, result)
+	secret.Annotations = annotations
+	secret.Labels = labels
+	secret.Data = data
+	if secretType != "" {
+		secret.Type = secretType
+	}
+	secret.Immutable = nil
+	if sopsSecret.Spec.Immutable {
+		immutable := true
+		secret.Immutable = &immutable
+	}
+
+	logger.Info("setting owner")
+	return r.setOwner(secret, sopsSecret)
+}
+
+// generator decrypts SopsSecrets and generates the data of the objects generated from them.
+// It is shared by the reconcilers of SopsSecrets and SopsConfigMaps.
+type generator struct {
+	client.Reader
+	Decryptor Decryptor
+	// HTTPClient downloads the artifacts of Flux sources.
+	HTTPClient *http.Client
+	// FluxSources are the versions Flux sources are read in by kind.
+	FluxSources map[craftypathgithubiov1alpha1.SourceKind]schema.GroupVersionKind
+	// HashKey is the key the hashes of decrypted entries are keyed with.
+	HashKey []byte
+
+	// artifacts caches downloaded artifacts by URL.
+	artifacts map[string][]byte
 }
 
-func (r *SopsSecretReconciler) update(ctx context.Context, secret *corev1.Secret, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) error {
+// generate decrypts the SopsSecret and returns the contents of the Secrets generated from it. The previous
+// data is used to keep the output of non-deterministic template functions stable.
+func (g *generator) generate(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, previous map[string][]byte) (*generatedSecret, []craftypathgithubiov1alpha1.SopsSecretKeyStatus, error) {
 	logger := log.FromContext(ctx)
-	logger.Info("handling Secret update")
+	logger.Info("generating Secret contents")
+
+	keys, err := g.decryptionKeys(ctx, sopsSecret)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	data := make(map[string][]byte, len(sopsSecret.Spec.StringData))
-	for fileName, encryptedContents := range sopsSecret.Spec.StringData {
+	if sopsSecret.Spec.Manifest != "" && (len(sopsSecret.Spec.StringData) > 0 || len(sopsSecret.Spec.Data) > 0) {
+		return nil, nil, fmt.Errorf("manifest must not be specified together with stringData or data")
+	}
+	if sopsSecret.Spec.Manifest != "" && len(sopsSecret.Spec.SourceRefs) > 0 {
+		return nil, nil, fmt.Errorf("manifest must not be specified together with sourceRefs")
+	}
+	// Entries of source refs are processed as part of stringData from here on
+	sopsSecret, err = g.withSources(ctx, sopsSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	for fileName := range sopsSecret.Spec.Data {
+		if _, exists := sopsSecret.Spec.StringData[fileName]; exists {
+			return nil, nil, fmt.Errorf("key %q must not be specified in both stringData and data", fileName)
+		}
+	}
+	for fileName, options := range sopsSecret.Spec.Options {
+		_, inStringData := sopsSecret.Spec.StringData[fileName]
+		_, inData := sopsSecret.Spec.Data[fileName]
+		if !inStringData && !inData {
+			return nil, nil, fmt.Errorf("options specified for key %q which is neither in stringData nor data", fileName)
+		}
+		if options.Key != "" {
+			if options.Expand != nil {
+				return nil, nil, fmt.Errorf("options for key %q must not specify both key and expand", fileName)
+			}
+			if errs := validation.IsConfigMapKey(options.Key); len(errs) > 0 {
+				return nil, nil, fmt.Errorf("invalid key %q in options for key %q: %s", options.Key, fileName, strings.Join(errs, ", "))
+			}
+		}
+	}
+
+	annotations := sopsSecret.Spec.Metadata.Annotations
+	labels := sopsSecret.Spec.Metadata.Labels
+	secretType := sopsSecret.Spec.Type
+
+	decrypted := make(map[string][]byte, len(sopsSecret.Spec.StringData)+len(sopsSecret.Spec.Data))
+	if sopsSecret.Spec.Manifest != "" {
+		logger.Info("decrypting manifest")
+		manifest, err := g.decryptManifest(sopsSecret.Spec.Manifest, keys)
+		if err != nil {
+			return nil, nil, &reasonError{reason: reasonDecryptionFailed, err: err}
+		}
+		for key, value := range manifest.Data {
+			decrypted[key] = value
+		}
+		for key, value := range manifest.StringData {
+			decrypted[key] = []byte(value)
+		}
+		annotations = mergeStringMaps(manifest.Annotations, annotations)
+		labels = mergeStringMaps(manifest.Labels, labels)
+		if secretType == "" {
+			secretType = manifest.Type
+		}
+	}
+	// All entries are decrypted in a stable order so that the status reports every broken entry
+	decryptErrs := make(map[string]error)
+	for _, fileName := range sortedStringKeys(sopsSecret.Spec.StringData) {
 		logger.Info("decrypting data", "fileName", fileName)
-		decrypted, err := r.Decryptor.Decrypt(fileName, encryptedContents)
+		decryptedContents, err := g.Decryptor.Decrypt(sops.FileNameWithFormat(fileName, entryFormat(sopsSecret, fileName)), sopsSecret.Spec.StringData[fileName], keys)
+		if err != nil {
+			decryptErrs[fileName] = err
+			continue
+		}
+		decrypted[fileName] = decryptedContents
+	}
+	for _, fileName := range sortedDataKeys(sopsSecret.Spec.Data) {
+		logger.Info("decrypting binary data", "fileName", fileName)
+		decryptedContents, err := g.Decryptor.Decrypt(sops.FileNameWithFormat(fileName, entryFormat(sopsSecret, fileName)), string(sopsSecret.Spec.Data[fileName]), keys)
 		if err != nil {
-			return err
+			decryptErrs[fileName] = err
+			continue
 		}
-		data[fileName] = decrypted
+		decrypted[fileName] = decryptedContents
 	}
 
-	secret.Annotations = sopsSecret.Spec.Metadata.Annotations
-	secret.Labels = sopsSecret.Spec.Metadata.Labels
-	secret.Data = data
-	if sopsSecret.Spec.Type != "" {
-		secret.Type = sopsSecret.Spec.Type
+	keyStatuses := newKeyStatuses(g.HashKey, sopsSecret, decrypted, decryptErrs)
+	var failed []string
+	// keptKeys holds the previous keys of expanded entries that failed
+	var keptKeys []string
+	for _, status := range keyStatuses {
+		err := decryptErrs[status.Name]
+		if err == nil {
+			continue
+		}
+		if sopsSecret.Spec.FailurePolicy != craftypathgithubiov1alpha1.FailurePolicyBestEffort {
+			return nil, keyStatuses, &reasonError{reason: reasonDecryptionFailed, err: err}
+		}
+		failed = append(failed, status.Name)
+		// The plaintext of expanded entries is not stored, so the keys they previously expanded to are kept below
+		if sopsSecret.Spec.Options[status.Name].Expand != nil {
+			keptKeys = append(keptKeys, status.ExpandedKeys...)
+		} else if value, exists := previous[entryKey(sopsSecret, status.Name)]; exists {
+			decrypted[status.Name] = value
+		}
+	}
+
+	data := make(map[string][]byte, len(decrypted)+len(sopsSecret.Spec.Template))
+	// Entries are processed in a stable order so that conflicts are always reported for the same entry
+	for _, fileName := range sortedDataKeys(decrypted) {
+		options := sopsSecret.Spec.Options[fileName]
+		if options.Expand == nil {
+			key := entryKey(sopsSecret, fileName)
+			if _, exists := data[key]; exists {
+				if key != fileName {
+					return nil, keyStatuses, fmt.Errorf("key %q of entry %q conflicts with another entry", key, fileName)
+				}
+				return nil, keyStatuses, fmt.Errorf("key %q conflicts with a key expanded from another entry", key)
+			}
+			data[key] = decrypted[fileName]
+			continue
+		}
+
+		logger.Info("expanding data", "fileName", fileName)
+		expanded, err := expand(fileName, entryFormat(sopsSecret, fileName), decrypted[fileName], options.Expand)
+		if err != nil {
+			return nil, keyStatuses, err
+		}
+		expandedKeys := sortedDataKeys(expanded)
+		for _, key := range expandedKeys {
+			if _, exists := data[key]; exists {
+				return nil, keyStatuses, fmt.Errorf("key %q expanded from %q conflicts with another entry", key, fileName)
+			}
+			data[key] = expanded[key]
+		}
+		for i := range keyStatuses {
+			if keyStatuses[i].Name == fileName {
+				keyStatuses[i].ExpandedKeys = expandedKeys
+			}
+		}
 	}
 
-	logger.Info("setting controller reference")
-	if err := ctrl.SetControllerReference(sopsSecret, secret, r.Scheme); err != nil {
-		return fmt.Errorf("unable to set ownerReference: %w", err)
+	for _, key := range keptKeys {
+		if _, isTemplate := sopsSecret.Spec.Template[key]; isTemplate {
+			continue
+		}
+		if value, exists := previous[key]; exists {
+			if _, generated := data[key]; !generated {
+				data[key] = value
+			}
+		}
 	}
-	return nil
-}
 
-func (r *SopsSecretReconciler) manageError(ctx context.Context, instance *craftypathgithubiov1alpha1.SopsSecret, issue error) (reconcile.Result, error) {
-	logger := log.FromContext(ctx)
-	logger.Info("handling reconciliation error")
+	if len(sopsSecret.Spec.Template) > 0 {
+		logger.Info("rendering templates")
+		formats := make(map[string]string, len(decrypted))
+		for fileName := range decrypted {
+			formats[fileName] = entryFormat(sopsSecret, fileName)
+		}
+		rendered, err := renderTemplates(sopsSecret.Spec.Template, decrypted, formats, previous)
+		if err != nil {
+			return nil, keyStatuses, err
+		}
+		for _, key := range sortedDataKeys(rendered) {
+			if _, exists := data[key]; exists {
+				return nil, keyStatuses, fmt.Errorf("template %q conflicts with another entry", key)
+			}
+			data[key] = rendered[key]
+		}
+	}
 
-	r.Recorder.Event(instance, "Warning", "ProcessingError", capitalizeFirst(issue.Error()))
+	return &generatedSecret{
+		annotations: annotations,
+		labels:      labels,
+		secretType:  secretType,
+		data:        data,
+		failed:      failed,
+	}, keyStatuses, nil
+}
 
-	lastUpdate := instance.Status.LastUpdate
-	lastStatus := instance.Status.Status
+// entryFormat returns the format of the given entry of the SopsSecret, which is determined
+// by the extension of its name unless overridden in its options.
+func entryFormat(sopsSecret *craftypathgithubiov1alpha1.SopsSecret, fileName string) string {
+	if format := sopsSecret.Spec.Options[fileName].Format; format != "" {
+		return string(format)
+	}
+	return sops.FileFormat(fileName)
+}
 
-	status := craftypathgithubiov1alpha1.SopsSecretStatus{
-		LastUpdate: metav1.Now(),
-		Reason:     issue.Error(),
-		Status:     "Failure",
+// entryKey returns the key of the given entry of the SopsSecret in generated Secrets.
+func entryKey(sopsSecret *craftypathgithubiov1alpha1.SopsSecret, fileName string) string {
+	if key := sopsSecret.Spec.Options[fileName].Key; key != "" {
+		return key
 	}
-	instance.Status = status
+	return fileName
+}
 
-	if err := r.Status().Update(ctx, instance); err != nil {
-		logger.Error(err, "unable to update status")
-		return reconcile.Result{
-			RequeueAfter: time.Second,
-			Requeue:      true,
-		}, nil
+// decryptManifest decrypts the given Sops-encrypted Secret manifest.
+func (g *generator) decryptManifest(manifest string, keys *sops.Keys) (*corev1.Secret, error) {
+	decrypted, err := g.Decryptor.Decrypt("manifest.yaml", manifest, keys)
+	if err != nil {
+		return nil, err
 	}
 
-	var retryInterval time.Duration
-	if lastUpdate.IsZero() || lastStatus == "Success" {
-		retryInterval = time.Second
-	} else {
-		retryInterval = status.LastUpdate.Sub(lastUpdate.Time.Round(time.Second))
+	secret := &corev1.Secret{}
+	if err := yaml.Unmarshal(decrypted, secret); err != nil {
+		return nil, fmt.Errorf("unable to parse manifest: %w", err)
+	}
+	if secret.Kind != "" && secret.Kind != "Secret" {
+		return nil, fmt.Errorf("manifest must be of kind Secret, got %q", secret.Kind)
 	}
+	return secret, nil
+}
 
-	reqeueAfter := time.Duration(math.Min(float64(retryInterval.Nanoseconds()*2), float64(time.Hour.Nanoseconds()*6)))
-	logger.Error(issue, "failed to reconcile SopsSecret", "reqeueAfter", reqeueAfter)
-	return reconcile.Result{
-		RequeueAfter: reqeueAfter,
-		Requeue:      true,
-	}, nil
+// mergeStringMaps returns a map containing the entries of both maps, with the entries of override taking precedence.
+func mergeStringMaps(base, override map[string]string) map[string]string {
+	if len(base) == 0 {
+		return override
+	}
+	if len(override) == 0 {
+		return base
+	}
+	merged := make(map[string]string, len(base)+len(override))
+	for key, value := range base {
+		merged[key] = value
+	}
+	for key, value := range override {
+		merged[key] = value
+	}
+	return merged
 }
 
-func (r *SopsSecretReconciler) manageSuccess(ctx context.Context, instance *craftypathgithubiov1alpha1.SopsSecret, result controllerutil.OperationResult) (reconcile.Result, error) {
-	logger := log.FromContext(ctx)
-	logger.Info("handling reconciliation success")
+// decryptionKeys returns the keys from the Secret referenced by the SopsSecret's key ref,
+// or nil if the SopsSecret has no key ref.
+func (g *generator) decryptionKeys(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) (*sops.Keys, error) {
+	if sopsSecret.Spec.Decryption == nil || sopsSecret.Spec.Decryption.KeyRef == nil {
+		return nil, nil
+	}
 
-	if result == controllerutil.OperationResultNone {
-		return reconcile.Result{}, nil
+	keyRef := sopsSecret.Spec.Decryption.KeyRef
+	keySecret := &corev1.Secret{}
+	if err := g.Get(ctx, types.NamespacedName{Namespace: sopsSecret.Namespace, Name: keyRef.Name}, keySecret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, &reasonError{
+				reason: reasonKeyRefNotFound,
+				err:    fmt.Errorf("key secret %q not found", keyRef.Name),
+			}
+		}
+		return nil, fmt.Errorf("unable to get key secret %q: %w", keyRef.Name, err)
 	}
 
-	status := craftypathgithubiov1alpha1.SopsSecretStatus{
-		LastUpdate: metav1.Now(),
-		Reason:     "",
-		Status:     "Success",
+	keys, err := sops.KeysFromSecretData(keySecret.Data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keys from secret %q: %w", keyRef.Name, err)
 	}
-	instance.Status = status
+	return keys, nil
+}
 
-	if err := r.Status().Update(ctx, instance); err != nil {
-		logger.Error(err, "unable to update status")
-		r.Recorder.Event(instance, "Warning", "ProcessingError", "Unable to update status")
-		return reconcile.Result{
-			RequeueAfter: time.Second,
-			Requeue:      true,
-		}, nil
+// manageError records the given error as the reason of the given condition being false and requeues the SopsSecret.
+func (r *SopsSecretReconciler) manageError(ctx context.Context, instance *craftypathgithubiov1alpha1.SopsSecret, conditionType string, issue error) (reconcile.Result, error) {
+	return manageError(ctx, r.Client, r.Recorder, &r.backoff, instance, conditionType, issue)
+}
+
+// reasonFor returns the reason reported for the given error.
+func reasonFor(err error) string {
+	var reasonErr *reasonError
+	if errors.As(err, &reasonErr) {
+		return reasonErr.reason
+	}
+	return reasonProcessingError
+}
+
+func (r *SopsSecretReconciler) manageSuccess(ctx context.Context, instance *craftypathgithubiov1alpha1.SopsSecret, targets []generatedTarget, statuses []craftypathgithubiov1alpha1.SopsSecretTargetStatus, results []controllerutil.OperationResult) (reconcile.Result, error) {
+	instance.Status.Target = nil
+	instance.Status.Targets = nil
+	if len(instance.Spec.Targets) > 0 {
+		instance.Status.Targets = statuses
+	} else {
+		instance.Status.Target = &targets[0].ref
+	}
+	if result, ok := manageSuccess(ctx, r.Client, r.Recorder, &r.backoff, instance, craftypathgithubiov1alpha1.ConditionTypeSecretSynced, "Secrets synced successfully"); !ok {
+		return result, nil
 	}
 
-	opResult := capitalizeFirst(string(result))
-	msg := fmt.Sprintf("%s secret: %s", opResult, instance.Name)
-	logger.Info("status updated successfully: " + msg)
-	r.Recorder.Event(instance, "Normal", opResult, msg)
+	logger := log.FromContext(ctx)
+	for i, result := range results {
+		if result == controllerutil.OperationResultNone {
+			continue
+		}
+		opResult := capitalizeFirst(string(result))
+		msg := fmt.Sprintf("%s secret: %s", opResult, targets[i].ref.Name)
+		logger.Info("status updated successfully: " + msg)
+		r.Recorder.Event(instance, "Normal", opResult, msg)
+	}
 	return reconcile.Result{}, nil
 }
 
@@ -196,10 +709,69 @@ func capitalizeFirst(s string) string {
 	return string(tmp)
 }
 
+// keyRefIndexKey indexes SopsSecrets and SopsConfigMaps by the name of the Secret referenced as key ref.
+const keyRefIndexKey = ".spec.decryption.keyRef.name"
+
+// keyRefIndexValues returns the values of the key ref index for the given decryption settings.
+func keyRefIndexValues(decryption *craftypathgithubiov1alpha1.SopsSecretDecryption) []string {
+	if decryption == nil || decryption.KeyRef == nil {
+		return nil
+	}
+	return []string{decryption.KeyRef.Name}
+}
+
+// findSopsSecretsForKeySecret returns requests for all SopsSecrets referencing the given Secret as key ref.
+func (r *SopsSecretReconciler) findSopsSecretsForKeySecret(obj client.Object) []reconcile.Request {
+	sopsSecrets := &craftypathgithubiov1alpha1.SopsSecretList{}
+	if err := r.List(context.Background(), sopsSecrets, client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{keyRefIndexKey: obj.GetName()}); err != nil {
+		log.Log.Error(err, "unable to list SopsSecrets", "namespace", obj.GetNamespace())
+		return nil
+	}
+
+	requests := make([]reconcile.Request, 0, len(sopsSecrets.Items))
+	for _, sopsSecret := range sopsSecrets.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: sopsSecret.Namespace, Name: sopsSecret.Name},
+		})
+	}
+	return requests
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *SopsSecretReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &craftypathgithubiov1alpha1.SopsSecret{}, sourceRefIndexKey, sourceRefIndexValues); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(context.Background(), &craftypathgithubiov1alpha1.SopsSecret{}, keyRefIndexKey, func(obj client.Object) []string {
+		return keyRefIndexValues(obj.(*craftypathgithubiov1alpha1.SopsSecret).Spec.Decryption)
+	}); err != nil {
+		return err
+	}
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&craftypathgithubiov1alpha1.SopsSecret{}).
 		Owns(&corev1.Secret{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findSopsSecretsForKeySecret)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findSopsSecretForTargetSecret)).
+		Watches(&source.Kind{Type: &corev1.Secret{}}, handler.EnqueueRequestsFromMapFunc(r.findSopsSecretsForSource(craftypathgithubiov1alpha1.SourceKindSecret))).
+		Watches(&source.Kind{Type: &corev1.ConfigMap{}}, handler.EnqueueRequestsFromMapFunc(r.findSopsSecretsForSource(craftypathgithubiov1alpha1.SourceKindConfigMap)))
+
+	// Flux sources are only watched if their CRDs are installed.
+	fluxSources, err := fluxSourceVersions(mgr.GetRESTMapper())
+	if err != nil {
+		return err
+	}
+	r.fluxSources = fluxSources
+	for _, kind := range fluxSourceKinds {
+		gvk, installed := fluxSources[kind]
+		if !installed {
+			mgr.GetLogger().Info("not watching Flux sources, CRD not installed", "kind", kind)
+			continue
+		}
+		src := &unstructured.Unstructured{}
+		src.SetGroupVersionKind(gvk)
+		b = b.Watches(&source.Kind{Type: src}, handler.EnqueueRequestsFromMapFunc(r.findSopsSecretsForSource(kind)),
+			builder.WithPredicates(artifactRevisionChanged))
+	}
+	return b.Complete(r)
 }
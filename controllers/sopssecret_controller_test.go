@@ -18,31 +18,65 @@ package controllers
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"os"
 	"testing"
+	"time"
 
 	"go.uber.org/zap/zapcore"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 
+	"filippo.io/age"
 	"github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	uberzap "go.uber.org/zap"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 )
 
-type FakeDecryptor struct{}
+type FakeDecryptor struct {
+	keys      *sops.Keys
+	decrypted string
+	err       error
+	errs      map[string]error
+	// files holds the decrypted contents of individual files, taking precedence over decrypted.
+	files map[string]string
+	// encrypted records the encrypted contents passed for each file.
+	encrypted map[string]string
+}
 
-func (f *FakeDecryptor) Decrypt(fileName string, encrypted string) ([]byte, error) {
+func (f *FakeDecryptor) Decrypt(fileName string, encrypted string, keys *sops.Keys) ([]byte, error) {
+	f.keys = keys
+	if f.encrypted == nil {
+		f.encrypted = make(map[string]string)
+	}
+	f.encrypted[fileName] = encrypted
+	if f.err != nil {
+		return nil, f.err
+	}
+	if err := f.errs[fileName]; err != nil {
+		return nil, err
+	}
+	if decrypted, exists := f.files[fileName]; exists {
+		return []byte(decrypted), nil
+	}
+	if f.decrypted != "" {
+		return []byte(f.decrypted), nil
+	}
 	return []byte("unencrypted"), nil
 }
 
@@ -176,20 +210,85 @@ func TestReconcile_Update(t *testing.T) {
 	assert.Equal(t, event, "Normal Updated Updated secret: test-secret")
 }
 
-func TestExistingSecretNotOwnedByUs(t *testing.T) {
-	secret := &corev1.Secret{
+func TestReconcile_StatusRecoversWithoutSecretChange(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
 		ObjectMeta: metav1.ObjectMeta{
-			Name:              name,
-			Namespace:         namespace,
-			CreationTimestamp: metav1.Now(),
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData: map[string]string{"test.yaml": "encrypted"},
 		},
 	}
 
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+	decryptor := r.Decryptor.(*FakeDecryptor)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	require.Len(t, sopsSecret.Status.Keys, 1)
+	assert.Equal(t, "test.yaml", sopsSecret.Status.Keys[0].Name)
+	assert.Equal(t, "yaml", sopsSecret.Status.Keys[0].Format)
+	assert.Equal(t, int64(len("unencrypted")), sopsSecret.Status.Keys[0].Size)
+	assert.NotEmpty(t, sopsSecret.Status.Keys[0].Hash)
+	assert.NotEmpty(t, sopsSecret.Status.DataHash)
+	hash := sopsSecret.Status.Keys[0].Hash
+
+	decryptor.err = errors.New("kms unavailable")
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Warning DecryptionFailed Failed to update secret: kms unavailable", <-recorder.Events)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.False(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+	// The deprecated status fields are derived from the Ready condition
+	assert.Equal(t, "Failure", sopsSecret.Status.Status)
+	assert.Equal(t, "failed to update secret: kms unavailable", sopsSecret.Status.Reason)
+	require.Len(t, sopsSecret.Status.Keys, 1)
+	assert.Equal(t, "kms unavailable", sopsSecret.Status.Keys[0].Error)
+	assert.Equal(t, hash, sopsSecret.Status.Keys[0].Hash)
+
+	// The Secret does not change, but the status must still be updated
+	decryptor.err = nil
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, recorder.Events)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.True(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+	assert.True(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDecrypted))
+	assert.Equal(t, "Success", sopsSecret.Status.Status)
+	assert.Empty(t, sopsSecret.Status.Reason)
+
+	// The status is not written if it does not change
+	resourceVersion := sopsSecret.ResourceVersion
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.Equal(t, resourceVersion, sopsSecret.ResourceVersion)
+}
+
+func TestReconcile_DeletedWhileFailing(t *testing.T) {
 	sopsSecret := &v1alpha1.SopsSecret{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
 		},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData: map[string]string{"test.yaml": "encrypted"},
+		},
 	}
 
 	s := runtime.NewScheme()
@@ -197,28 +296,1530 @@ func TestExistingSecretNotOwnedByUs(t *testing.T) {
 	utilruntime.Must(v1alpha1.AddToScheme(s))
 
 	recorder := record.NewFakeRecorder(1)
-	r := newSopsSecretReconciler(s, recorder, secret, sopsSecret)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+	r.Decryptor.(*FakeDecryptor).err = errors.New("kms unavailable")
+
+	result, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, minRetryInterval, result.RequeueAfter)
+	assert.Equal(t, "Warning DecryptionFailed Failed to update secret: kms unavailable", <-recorder.Events)
+	assert.Contains(t, r.backoff.intervals, req.NamespacedName)
+
+	// The retry interval is forgotten once the SopsSecret is gone
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	require.NoError(t, r.Delete(context.Background(), sopsSecret))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Empty(t, r.backoff.intervals)
+}
+
+func TestReconcile_FailurePolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   v1alpha1.FailurePolicy
+		wantData map[string][]byte
+		wantErr  string
+	}{
+		{
+			name:     "all or nothing",
+			policy:   v1alpha1.FailurePolicyAllOrNothing,
+			wantData: map[string][]byte{"a.yaml": []byte("unencrypted"), "b.yaml": []byte("unencrypted"), "c.yaml": []byte("unencrypted")},
+			wantErr:  "Warning DecryptionFailed Failed to update secret: kms unavailable",
+		},
+		{
+			name:   "best effort",
+			policy: v1alpha1.FailurePolicyBestEffort,
+			wantData: map[string][]byte{
+				"a.yaml": []byte("unencrypted"),
+				"b.yaml": []byte("rotated"),
+				"c.yaml": []byte("rotated"),
+				"d.yaml": []byte("rotated"),
+				"e.yaml": []byte("rotated"),
+			},
+			wantErr: "Warning DecryptionFailed Failed to decrypt keys a.yaml, f.yaml, keeping their last good values",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					FailurePolicy: tt.policy,
+					StringData:    map[string]string{"a.yaml": "encrypted", "b.yaml": "encrypted", "c.yaml": "encrypted"},
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(2)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+			decryptor := r.Decryptor.(*FakeDecryptor)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			sopsSecret.Spec.StringData["d.yaml"] = "encrypted"
+			sopsSecret.Spec.StringData["e.yaml"] = "encrypted"
+			sopsSecret.Spec.StringData["f.yaml"] = "encrypted"
+			err = r.Update(context.Background(), sopsSecret)
+			require.NoError(t, err)
+
+			// a.yaml has a last good value, f.yaml is new and has none
+			decryptor.decrypted = "rotated"
+			decryptor.errs = map[string]error{"a.yaml": errors.New("kms unavailable"), "f.yaml": errors.New("kms unavailable")}
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantErr, <-recorder.Events)
+
+			secret := &corev1.Secret{}
+			err = r.Get(context.Background(), req.NamespacedName, secret)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantData, secret.Data)
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			degraded := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDegraded)
+			if tt.policy != v1alpha1.FailurePolicyBestEffort {
+				assert.False(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+				return
+			}
+			assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+			require.NotNil(t, degraded)
+			assert.Equal(t, metav1.ConditionTrue, degraded.Status)
+			assert.Equal(t, "failed to decrypt keys a.yaml, f.yaml, keeping their last good values", degraded.Message)
+			assert.True(t, meta.IsStatusConditionFalse(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDecrypted))
+			assert.True(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+
+			decryptor.errs = nil
+			res, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Zero(t, res.RequeueAfter)
+			assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			assert.True(t, meta.IsStatusConditionFalse(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDegraded))
+		})
+	}
+}
+
+func TestReconcile_BestEffortExpand(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			FailurePolicy: v1alpha1.FailurePolicyBestEffort,
+			StringData:    map[string]string{"db.yaml": "encrypted", "app.yaml": "encrypted"},
+			Options: map[string]v1alpha1.SopsSecretEntryOptions{
+				"db.yaml":  {Expand: &v1alpha1.SopsSecretExpand{}},
+				"app.yaml": {Expand: &v1alpha1.SopsSecretExpand{}},
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(2)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+	decryptor := &FakeDecryptor{files: map[string]string{
+		"db.yaml":  "user: admin\npassword: secret\n",
+		"app.yaml": "name: app\n",
+	}}
+	r.Decryptor = decryptor
 
 	_, err := r.Reconcile(context.Background(), req)
 	require.NoError(t, err)
-	event := <-recorder.Events
-	assert.Contains(t, event, "Secret already exists and not owned by sops-operator")
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
 
-	err = r.Delete(context.Background(), secret)
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
 	require.NoError(t, err)
+	require.Len(t, sopsSecret.Status.Keys, 2)
+	assert.Equal(t, []string{"name"}, sopsSecret.Status.Keys[0].ExpandedKeys)
+	assert.Equal(t, []string{"password", "user"}, sopsSecret.Status.Keys[1].ExpandedKeys)
 
+	// Only the keys expanded from the failed entry are kept, not those of the removed entry
+	delete(sopsSecret.Spec.StringData, "app.yaml")
+	delete(sopsSecret.Spec.Options, "app.yaml")
+	err = r.Update(context.Background(), sopsSecret)
+	require.NoError(t, err)
+	decryptor.errs = map[string]error{"db.yaml": errors.New("kms unavailable")}
 	_, err = r.Reconcile(context.Background(), req)
 	require.NoError(t, err)
-	event = <-recorder.Events
-	assert.Contains(t, event, "Normal Created Created secret: test-secret")
+	assert.Equal(t, "Warning DecryptionFailed Failed to decrypt keys db.yaml, keeping their last good values", <-recorder.Events)
+	assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+
+	secret := &corev1.Secret{}
+	err = r.Get(context.Background(), req.NamespacedName, secret)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"user": []byte("admin"), "password": []byte("secret")}, secret.Data)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	require.Len(t, sopsSecret.Status.Keys, 1)
+	assert.Equal(t, []string{"password", "user"}, sopsSecret.Status.Keys[0].ExpandedKeys)
 }
 
-func newSopsSecretReconciler(s *runtime.Scheme, recorder *record.FakeRecorder, objs ...runtime.Object) *SopsSecretReconciler {
-	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
-	return &SopsSecretReconciler{
-		Client:    cl,
-		Scheme:    s,
-		Recorder:  recorder,
-		Decryptor: &FakeDecryptor{},
+func TestReconcile_RefreshInterval(t *testing.T) {
+	tests := []struct {
+		name            string
+		defaultInterval time.Duration
+		specInterval    *metav1.Duration
+		wantInterval    time.Duration
+	}{
+		{
+			name: "disabled by default",
+		},
+		{
+			name:            "operator default",
+			defaultInterval: time.Hour,
+			wantInterval:    time.Hour,
+		},
+		{
+			name:            "spec overrides default",
+			defaultInterval: time.Hour,
+			specInterval:    &metav1.Duration{Duration: 10 * time.Minute},
+			wantInterval:    10 * time.Minute,
+		},
+		{
+			name:            "spec disables default",
+			defaultInterval: time.Hour,
+			specInterval:    &metav1.Duration{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					StringData:      map[string]string{"test.yaml": "encrypted"},
+					RefreshInterval: tt.specInterval,
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+			r.RefreshInterval = tt.defaultInterval
+
+			res, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+			assert.GreaterOrEqual(t, res.RequeueAfter, tt.wantInterval)
+			assert.LessOrEqual(t, res.RequeueAfter, time.Duration(float64(tt.wantInterval)*(1+refreshJitter)))
+		})
+	}
+}
+
+func TestReconcile_RefreshDetectsUnusableKeys(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData:      map[string]string{"test.yaml": "encrypted"},
+			RefreshInterval: &metav1.Duration{Duration: time.Hour},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+	decryptor := r.Decryptor.(*FakeDecryptor)
+
+	res, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+	assert.GreaterOrEqual(t, res.RequeueAfter, time.Hour)
+
+	// The key is revoked without any change to the SopsSecret
+	decryptor.err = errors.New("access denied")
+	res, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.True(t, res.Requeue)
+	assert.Less(t, res.RequeueAfter, time.Hour)
+	assert.Equal(t, "Warning DecryptionFailed Failed to update secret: access denied", <-recorder.Events)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	decrypted := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDecrypted)
+	require.NotNil(t, decrypted)
+	assert.Equal(t, metav1.ConditionFalse, decrypted.Status)
+	assert.Equal(t, "DecryptionFailed", decrypted.Reason)
+	assert.False(t, meta.IsStatusConditionTrue(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady))
+
+	// The generated Secret keeps its data
+	secret := &corev1.Secret{}
+	err = r.Get(context.Background(), req.NamespacedName, secret)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+}
+
+func TestReconcile_Data(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      v1alpha1.SopsSecretSpec
+		wantData  map[string][]byte
+		wantEvent string
+	}{
+		{
+			name: "stringData and data",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"test.yaml": "encrypted"},
+				Data:       map[string][]byte{"keystore.jks": []byte("encrypted")},
+			},
+			wantData: map[string][]byte{
+				"test.yaml":    []byte("unencrypted"),
+				"keystore.jks": []byte("unencrypted"),
+			},
+			wantEvent: "Normal Created Created secret: test-secret",
+		},
+		{
+			name: "key in stringData and data",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"test.yaml": "encrypted"},
+				Data:       map[string][]byte{"test.yaml": []byte("encrypted")},
+			},
+			wantEvent: `Warning ProcessingError Failed to update secret: key "test.yaml" must not be specified in both stringData and data`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: tt.spec,
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			event := <-recorder.Events
+			assert.Equal(t, tt.wantEvent, event)
+
+			if tt.wantData != nil {
+				secret := &corev1.Secret{}
+				err = r.Get(context.Background(), req.NamespacedName, secret)
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantData, secret.Data)
+			}
+		})
+	}
+}
+
+func TestReconcile_Expand(t *testing.T) {
+	tests := []struct {
+		name      string
+		spec      v1alpha1.SopsSecretSpec
+		wantData  map[string][]byte
+		wantEvent string
+	}{
+		{
+			name: "expanded entry",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted", "config.yaml": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"db.yaml": {Expand: &v1alpha1.SopsSecretExpand{}},
+				},
+			},
+			wantData: map[string][]byte{
+				"config.yaml": []byte("user: admin\n"),
+				"user":        []byte("admin"),
+			},
+			wantEvent: "Normal Created Created secret: test-secret",
+		},
+		{
+			name: "conflicting keys",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted", "user": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"db.yaml": {Expand: &v1alpha1.SopsSecretExpand{}},
+				},
+			},
+			wantEvent: `Warning ProcessingError Failed to update secret: key "user" conflicts with a key expanded from another entry`,
+		},
+		{
+			name: "format override",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"db": {Format: v1alpha1.EntryFormatYAML, Expand: &v1alpha1.SopsSecretExpand{}},
+				},
+			},
+			wantData:  map[string][]byte{"user": []byte("admin")},
+			wantEvent: "Normal Created Created secret: test-secret",
+		},
+		{
+			name: "renamed entry",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"db.yaml": {Key: "database.yaml"},
+				},
+			},
+			wantData:  map[string][]byte{"database.yaml": []byte("user: admin\n")},
+			wantEvent: "Normal Created Created secret: test-secret",
+		},
+		{
+			name: "renamed entry conflicting with another entry",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted", "other.yaml": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"other.yaml": {Key: "db.yaml"},
+				},
+			},
+			wantEvent: `Warning ProcessingError Failed to update secret: key "db.yaml" of entry "other.yaml" conflicts with another entry`,
+		},
+		{
+			name: "key together with expand",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"db.yaml": {Key: "database.yaml", Expand: &v1alpha1.SopsSecretExpand{}},
+				},
+			},
+			wantEvent: `Warning ProcessingError Failed to update secret: options for key "db.yaml" must not specify both key and expand`,
+		},
+		{
+			name: "options for unknown entry",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted"},
+				Options: map[string]v1alpha1.SopsSecretEntryOptions{
+					"other.yaml": {Expand: &v1alpha1.SopsSecretExpand{}},
+				},
+			},
+			wantEvent: `Warning ProcessingError Failed to update secret: options specified for key "other.yaml" which is neither in stringData nor data`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: tt.spec,
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+			r.Decryptor = &FakeDecryptor{decrypted: "user: admin\n"}
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			event := <-recorder.Events
+			assert.Equal(t, tt.wantEvent, event)
+
+			if tt.wantData != nil {
+				secret := &corev1.Secret{}
+				err = r.Get(context.Background(), req.NamespacedName, secret)
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantData, secret.Data)
+			}
+		})
+	}
+}
+
+func TestReconcile_Template(t *testing.T) {
+	tests := []struct {
+		name      string
+		template  map[string]string
+		wantData  map[string][]byte
+		wantEvent string
+	}{
+		{
+			name:     "rendered template",
+			template: map[string]string{"username": `{{ (index .Data "db.yaml").user }}`},
+			wantData: map[string][]byte{
+				"db.yaml":  []byte("user: admin\n"),
+				"username": []byte("admin"),
+			},
+			wantEvent: "Normal Created Created secret: test-secret",
+		},
+		{
+			name:      "conflicting key",
+			template:  map[string]string{"db.yaml": `{{ (index .Data "db.yaml").user }}`},
+			wantEvent: `Warning ProcessingError Failed to update secret: template "db.yaml" conflicts with another entry`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					StringData: map[string]string{"db.yaml": "encrypted"},
+					Template:   tt.template,
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+			r.Decryptor = &FakeDecryptor{decrypted: "user: admin\n"}
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			event := <-recorder.Events
+			assert.Equal(t, tt.wantEvent, event)
+
+			if tt.wantData != nil {
+				secret := &corev1.Secret{}
+				err = r.Get(context.Background(), req.NamespacedName, secret)
+				require.NoError(t, err)
+				assert.Equal(t, tt.wantData, secret.Data)
+			}
+		})
+	}
+}
+
+func TestReconcile_Manifest(t *testing.T) {
+	manifest := `apiVersion: v1
+kind: Secret
+metadata:
+  name: original-name
+  labels:
+    mylabel: foo
+    overridden: manifest
+  annotations:
+    myannotation: bar
+type: kubernetes.io/basic-auth
+data:
+  username: YWRtaW4=
+stringData:
+  password: s3cr3t
+`
+
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			Metadata: v1alpha1.SopsSecretObjectMeta{
+				Labels: map[string]string{"overridden": "spec"},
+			},
+			Manifest: "encrypted",
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+	r.Decryptor = &FakeDecryptor{decrypted: manifest}
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event := <-recorder.Events
+	assert.Equal(t, "Normal Created Created secret: test-secret", event)
+
+	secret := &corev1.Secret{}
+	err = r.Get(context.Background(), req.NamespacedName, secret)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"username": []byte("admin"), "password": []byte("s3cr3t")}, secret.Data)
+	assert.Equal(t, corev1.SecretTypeBasicAuth, secret.Type)
+	assert.Equal(t, map[string]string{"mylabel": "foo", "overridden": "spec"}, secret.Labels)
+	assert.Equal(t, map[string]string{"myannotation": "bar"}, secret.Annotations)
+}
+
+func TestExistingSecretNotOwnedByUs(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			Namespace:         namespace,
+			CreationTimestamp: metav1.Now(),
+		},
+	}
+
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	r := newSopsSecretReconciler(s, recorder, secret, sopsSecret)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event := <-recorder.Events
+	assert.Contains(t, event, "Secret already exists and not owned by sops-operator")
+
+	err = r.Delete(context.Background(), secret)
+	require.NoError(t, err)
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event = <-recorder.Events
+	assert.Contains(t, event, "Normal Created Created secret: test-secret")
+}
+
+func TestReconcile_Adoption(t *testing.T) {
+	isController := true
+	tests := []struct {
+		name      string
+		policy    v1alpha1.AdoptionPolicy
+		labels    map[string]string
+		owners    []metav1.OwnerReference
+		wantEvent string
+	}{
+		{
+			name:      "fail",
+			policy:    v1alpha1.AdoptionPolicyFail,
+			wantEvent: "Warning ProcessingError Secret already exists and not owned by sops-operator",
+		},
+		{
+			name:      "adopt",
+			policy:    v1alpha1.AdoptionPolicyAdopt,
+			owners:    []metav1.OwnerReference{{APIVersion: "v1", Kind: "ConfigMap", Name: "owner", UID: "1234"}},
+			wantEvent: "Normal Adopted Adopted secret: test-secret",
+		},
+		{
+			name:      "adopt if labeled without label",
+			policy:    v1alpha1.AdoptionPolicyAdoptIfLabeled,
+			wantEvent: "Warning ProcessingError Secret already exists and not owned by sops-operator",
+		},
+		{
+			name:      "adopt if labeled with label",
+			policy:    v1alpha1.AdoptionPolicyAdoptIfLabeled,
+			labels:    map[string]string{"app.kubernetes.io/managed-by": "sops-operator"},
+			wantEvent: "Normal Adopted Adopted secret: test-secret",
+		},
+		{
+			name:      "adopt controlled by other controller",
+			policy:    v1alpha1.AdoptionPolicyAdopt,
+			owners:    []metav1.OwnerReference{{APIVersion: "apps/v1", Kind: "Deployment", Name: "other", UID: "1234", Controller: &isController}},
+			wantEvent: `Warning ProcessingError Secret already exists and is controlled by Deployment "other"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              name,
+					Namespace:         namespace,
+					CreationTimestamp: metav1.Now(),
+					Labels:            tt.labels,
+					OwnerReferences:   tt.owners,
+				},
+				Data: map[string][]byte{"test.yaml": []byte("existing")},
+			}
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					AdoptionPolicy: tt.policy,
+					StringData:     map[string]string{"test.yaml": "encrypted"},
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(2)
+			r := newSopsSecretReconciler(s, recorder, secret, sopsSecret)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvent, <-recorder.Events)
+
+			err = r.Get(context.Background(), req.NamespacedName, secret)
+			require.NoError(t, err)
+			if tt.wantEvent != "Normal Adopted Adopted secret: test-secret" {
+				assert.Equal(t, []byte("existing"), secret.Data["test.yaml"])
+				return
+			}
+
+			assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+			assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			assert.True(t, metav1.IsControlledBy(secret, sopsSecret))
+			// Owner references that do not control the Secret are kept
+			assert.Len(t, secret.OwnerReferences, len(tt.owners)+1)
+			for _, owner := range tt.owners {
+				assert.Contains(t, secret.OwnerReferences, owner)
+			}
+
+			var owner previousOwner
+			err = json.Unmarshal([]byte(secret.Annotations[previousOwnerAnnotation]), &owner)
+			require.NoError(t, err)
+			assert.Equal(t, tt.owners, owner.OwnerReferences)
+			assert.Equal(t, tt.labels["app.kubernetes.io/managed-by"], owner.ManagedBy)
+
+			// The annotation is kept on subsequent updates
+			sopsSecret.Spec.StringData["other.yaml"] = "encrypted"
+			err = r.Update(context.Background(), sopsSecret)
+			require.NoError(t, err)
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Updated Updated secret: test-secret", <-recorder.Events)
+			err = r.Get(context.Background(), req.NamespacedName, secret)
+			require.NoError(t, err)
+			assert.Contains(t, secret.Annotations, previousOwnerAnnotation)
+		})
+	}
+}
+
+func TestReconcile_DeletionPolicy(t *testing.T) {
+	tests := []struct {
+		name          string
+		policy        v1alpha1.DeletionPolicy
+		target        *v1alpha1.SopsSecretTarget
+		wantFinalizer bool
+		wantExists    bool
+	}{
+		{
+			name:   "delete",
+			policy: v1alpha1.DeletionPolicyDelete,
+		},
+		{
+			name:          "orphan",
+			policy:        v1alpha1.DeletionPolicyOrphan,
+			wantFinalizer: true,
+			wantExists:    true,
+		},
+		{
+			name:          "delete cross-namespace",
+			policy:        v1alpha1.DeletionPolicyDelete,
+			target:        &v1alpha1.SopsSecretTarget{Namespace: "other-namespace"},
+			wantFinalizer: true,
+		},
+		{
+			name:          "orphan cross-namespace",
+			policy:        v1alpha1.DeletionPolicyOrphan,
+			target:        &v1alpha1.SopsSecretTarget{Namespace: "other-namespace"},
+			wantFinalizer: true,
+			wantExists:    true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+					UID:       "1234",
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					Metadata: v1alpha1.SopsSecretObjectMeta{
+						Labels: map[string]string{"mylabel": "foo"},
+					},
+					DeletionPolicy: tt.policy,
+					Target:         tt.target,
+					StringData:     map[string]string{"test.yaml": "encrypted"},
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret, newAllowingNamespace("other-namespace"))
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			secret := &corev1.Secret{}
+			target := types.NamespacedName{Namespace: namespace, Name: name}
+			if tt.target != nil {
+				target.Namespace = tt.target.Namespace
+			}
+			if !tt.wantFinalizer {
+				// The garbage collector deletes the Secret through its owner reference
+				assert.Empty(t, sopsSecret.Finalizers)
+				require.NoError(t, r.Get(context.Background(), target, secret))
+				assert.True(t, metav1.IsControlledBy(secret, sopsSecret))
+				return
+			}
+			assert.Equal(t, []string{"craftypath.github.io/sopssecret"}, sopsSecret.Finalizers)
+
+			err = r.Delete(context.Background(), sopsSecret)
+			require.NoError(t, err)
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			assert.True(t, apierrors.IsNotFound(err))
+
+			err = r.Get(context.Background(), target, secret)
+			if !tt.wantExists {
+				assert.True(t, apierrors.IsNotFound(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, secret.OwnerReferences)
+			assert.Equal(t, map[string]string{"mylabel": "foo"}, secret.Labels)
+			assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+		})
+	}
+}
+
+func TestReconcile_Recreate(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     v1alpha1.RecreatePolicy
+		failCreate bool
+		wantEvent  string
+	}{
+		{
+			name:      "never",
+			policy:    v1alpha1.RecreatePolicyNever,
+			wantEvent: "Warning ImmutableFieldConflict Secret test-secret must be recreated to change its type, which requires recreatePolicy Allow",
+		},
+		{
+			name:      "allow",
+			policy:    v1alpha1.RecreatePolicyAllow,
+			wantEvent: "Normal Recreated Recreated secret test-secret to change its type",
+		},
+		{
+			name:       "allow with failing create",
+			policy:     v1alpha1.RecreatePolicyAllow,
+			failCreate: true,
+			wantEvent:  "Warning ProcessingError Create failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					RecreatePolicy: tt.policy,
+					Immutable:      true,
+					StringData:     map[string]string{"test.yaml": "encrypted"},
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(2)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+			secret := &corev1.Secret{}
+			err = r.Get(context.Background(), req.NamespacedName, secret)
+			require.NoError(t, err)
+			require.NotNil(t, secret.Immutable)
+			assert.True(t, *secret.Immutable)
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			sopsSecret.Spec.Type = corev1.SecretTypeBasicAuth
+			err = r.Update(context.Background(), sopsSecret)
+			require.NoError(t, err)
+
+			if tt.failCreate {
+				r.Client = &failingCreateClient{Client: r.Client}
+			}
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvent, <-recorder.Events)
+
+			err = r.Get(context.Background(), req.NamespacedName, secret)
+			if tt.failCreate {
+				// No Recreated event is emitted as long as the Secret is missing
+				assert.True(t, apierrors.IsNotFound(err))
+				assert.Empty(t, recorder.Events)
+				return
+			}
+			require.NoError(t, err)
+			if tt.policy != v1alpha1.RecreatePolicyAllow {
+				assert.Empty(t, secret.Type)
+				return
+			}
+			require.Len(t, recorder.Events, 1)
+			assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+			assert.Equal(t, corev1.SecretTypeBasicAuth, secret.Type)
+		})
+	}
+}
+
+func TestImmutableFieldConflict(t *testing.T) {
+	immutable := true
+	tests := []struct {
+		name     string
+		existing *corev1.Secret
+		desired  *corev1.Secret
+		want     string
+	}{
+		{
+			name:     "no conflict",
+			existing: &corev1.Secret{Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"a": []byte("1")}},
+			desired:  &corev1.Secret{Type: corev1.SecretTypeOpaque, Data: map[string][]byte{"a": []byte("2")}},
+		},
+		{
+			name:     "type",
+			existing: &corev1.Secret{Type: corev1.SecretTypeOpaque},
+			desired:  &corev1.Secret{Type: corev1.SecretTypeBasicAuth},
+			want:     "type",
+		},
+		{
+			name:     "making mutable",
+			existing: &corev1.Secret{Type: corev1.SecretTypeOpaque, Immutable: &immutable},
+			desired:  &corev1.Secret{Type: corev1.SecretTypeOpaque},
+			want:     "immutability",
+		},
+		{
+			name:     "making immutable",
+			existing: &corev1.Secret{Type: corev1.SecretTypeOpaque},
+			desired:  &corev1.Secret{Type: corev1.SecretTypeOpaque, Immutable: &immutable},
+		},
+		{
+			name:     "immutable data",
+			existing: &corev1.Secret{Type: corev1.SecretTypeOpaque, Immutable: &immutable, Data: map[string][]byte{"a": []byte("1")}},
+			desired:  &corev1.Secret{Type: corev1.SecretTypeOpaque, Immutable: &immutable, Data: map[string][]byte{"a": []byte("2")}},
+			want:     "data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, immutableFieldConflict(tt.existing, tt.desired))
+		})
+	}
+}
+
+func TestReconcile_KeyRef(t *testing.T) {
+	identity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData: map[string]string{"test.yaml": "encrypted"},
+			Decryption: &v1alpha1.SopsSecretDecryption{
+				KeyRef: &corev1.LocalObjectReference{Name: "keys"},
+			},
+		},
+	}
+	keySecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "keys",
+			Namespace: namespace,
+		},
+		Data: map[string][]byte{"identity.agekey": []byte(identity.String())},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event := <-recorder.Events
+	assert.Equal(t, `Warning KeyRefNotFound Failed to update secret: key secret "keys" not found`, event)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	ready := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady)
+	require.NotNil(t, ready)
+	assert.Equal(t, metav1.ConditionFalse, ready.Status)
+	assert.Equal(t, "KeyRefNotFound", ready.Reason)
+	decrypted := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeDecrypted)
+	require.NotNil(t, decrypted)
+	assert.Equal(t, metav1.ConditionFalse, decrypted.Status)
+	assert.Equal(t, "KeyRefNotFound", decrypted.Reason)
+
+	err = r.Create(context.Background(), keySecret)
+	require.NoError(t, err)
+	assert.Equal(t, []reconcile.Request{req}, r.findSopsSecretsForKeySecret(keySecret))
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event = <-recorder.Events
+	assert.Equal(t, "Normal Created Created secret: test-secret", event)
+
+	decryptor := r.Decryptor.(*FakeDecryptor)
+	require.NotNil(t, decryptor.keys)
+	assert.Contains(t, string(decryptor.keys.AgeIdentities), identity.String())
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.Equal(t, sopsSecret.Generation, sopsSecret.Status.ObservedGeneration)
+	for _, conditionType := range []string{v1alpha1.ConditionTypeReady, v1alpha1.ConditionTypeDecrypted, v1alpha1.ConditionTypeSecretSynced} {
+		condition := meta.FindStatusCondition(sopsSecret.Status.Conditions, conditionType)
+		require.NotNil(t, condition, conditionType)
+		assert.Equal(t, metav1.ConditionTrue, condition.Status, conditionType)
+		assert.Equal(t, "Reconciled", condition.Reason, conditionType)
+	}
+}
+
+func TestReconcile_TargetRename(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     v1alpha1.DeletionPolicy
+		wantOrphan bool
+	}{
+		{name: "delete", policy: v1alpha1.DeletionPolicyDelete},
+		{name: "orphan", policy: v1alpha1.DeletionPolicyOrphan, wantOrphan: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					StringData:     map[string]string{"test.yaml": "encrypted"},
+					DeletionPolicy: tt.policy,
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(1)
+			r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			event := <-recorder.Events
+			assert.Equal(t, "Normal Created Created secret: test-secret", event)
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			assert.Equal(t, &corev1.SecretReference{Name: name, Namespace: namespace}, sopsSecret.Status.Target)
+
+			sopsSecret.Spec.Target = &v1alpha1.SopsSecretTarget{Name: "renamed"}
+			err = r.Update(context.Background(), sopsSecret)
+			require.NoError(t, err)
+
+			_, err = r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			event = <-recorder.Events
+			assert.Equal(t, "Normal Created Created secret: renamed", event)
+
+			secret := &corev1.Secret{}
+			err = r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "renamed"}, secret)
+			require.NoError(t, err)
+			assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+			assert.True(t, metav1.IsControlledBy(secret, sopsSecret))
+
+			previous := &corev1.Secret{}
+			err = r.Get(context.Background(), req.NamespacedName, previous)
+			if tt.wantOrphan {
+				require.NoError(t, err)
+				assert.Equal(t, []byte("unencrypted"), previous.Data["test.yaml"])
+				assert.False(t, metav1.IsControlledBy(previous, sopsSecret))
+				assert.NotContains(t, previous.Labels, managedByLabel)
+			} else {
+				assert.True(t, apierrors.IsNotFound(err))
+			}
+
+			err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+			require.NoError(t, err)
+			assert.Equal(t, &corev1.SecretReference{Name: "renamed", Namespace: namespace}, sopsSecret.Status.Target)
+		})
+	}
+}
+
+func TestReconcile_CrossNamespaceTarget(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			Target:     &v1alpha1.SopsSecretTarget{Namespace: "other-namespace"},
+			StringData: map[string]string{"test.yaml": "encrypted"},
+		},
+	}
+	target := types.NamespacedName{Namespace: "other-namespace", Name: name}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event := <-recorder.Events
+	assert.Equal(t, `Warning TargetNotAllowed Target namespace "other-namespace" is not allowed, its annotation craftypath.github.io/allowed-source-namespaces does not list namespace "test-namespace"`, event)
+
+	secret := &corev1.Secret{}
+	err = r.Get(context.Background(), target, secret)
+	assert.True(t, apierrors.IsNotFound(err))
+
+	otherNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "other-namespace",
+			Annotations: map[string]string{"craftypath.github.io/allowed-source-namespaces": "team, other"},
+		},
+	}
+	require.NoError(t, r.Create(context.Background(), otherNamespace))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event = <-recorder.Events
+	assert.Equal(t, `Warning TargetNotAllowed Target namespace "other-namespace" is not allowed, its annotation craftypath.github.io/allowed-source-namespaces does not list namespace "test-namespace"`, event)
+
+	otherNamespace.Annotations["craftypath.github.io/allowed-source-namespaces"] = "team, test-namespace"
+	require.NoError(t, r.Update(context.Background(), otherNamespace))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	event = <-recorder.Events
+	assert.Equal(t, "Normal Created Created secret: test-secret", event)
+
+	err = r.Get(context.Background(), target, secret)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+	assert.Empty(t, secret.OwnerReferences)
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/managed-by":              "sops-operator",
+		"craftypath.github.io/sopssecret-name":      name,
+		"craftypath.github.io/sopssecret-namespace": namespace,
+	}, secret.Labels)
+	assert.Equal(t, []reconcile.Request{req}, r.findSopsSecretForTargetSecret(secret))
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"craftypath.github.io/sopssecret"}, sopsSecret.Finalizers)
+	assert.Equal(t, &corev1.SecretReference{Name: name, Namespace: "other-namespace"}, sopsSecret.Status.Target)
+
+	err = r.Delete(context.Background(), sopsSecret)
+	require.NoError(t, err)
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	err = r.Get(context.Background(), target, secret)
+	assert.True(t, apierrors.IsNotFound(err))
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	assert.True(t, apierrors.IsNotFound(err))
+}
+
+func TestReconcile_AdoptionInOtherNamespace(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		wantEvent   string
+	}{
+		{
+			name:        "not allowed",
+			annotations: map[string]string{"craftypath.github.io/allowed-source-namespaces": namespace},
+			wantEvent:   `Warning ProcessingError Secret already exists and namespace "other-namespace" does not allow adoption, its annotation craftypath.github.io/allowed-adoption-namespaces does not list namespace "test-namespace"`,
+		},
+		{
+			name: "allowed",
+			annotations: map[string]string{
+				"craftypath.github.io/allowed-source-namespaces":   namespace,
+				"craftypath.github.io/allowed-adoption-namespaces": namespace,
+			},
+			wantEvent: "Normal Adopted Adopted secret: test-secret",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			otherNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Annotations: tt.annotations}}
+			secret := &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              name,
+					Namespace:         "other-namespace",
+					CreationTimestamp: metav1.Now(),
+				},
+				Data: map[string][]byte{"test.yaml": []byte("existing")},
+			}
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      name,
+					Namespace: namespace,
+				},
+				Spec: v1alpha1.SopsSecretSpec{
+					Target:         &v1alpha1.SopsSecretTarget{Namespace: "other-namespace"},
+					AdoptionPolicy: v1alpha1.AdoptionPolicyAdopt,
+					StringData:     map[string]string{"test.yaml": "encrypted"},
+				},
+			}
+
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			recorder := record.NewFakeRecorder(2)
+			r := newSopsSecretReconciler(s, recorder, otherNamespace, secret, sopsSecret)
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvent, <-recorder.Events)
+
+			err = r.Get(context.Background(), types.NamespacedName{Namespace: "other-namespace", Name: name}, secret)
+			require.NoError(t, err)
+			if tt.wantEvent != "Normal Adopted Adopted secret: test-secret" {
+				assert.Equal(t, []byte("existing"), secret.Data["test.yaml"])
+				assert.Empty(t, secret.OwnerReferences)
+				return
+			}
+			assert.Equal(t, []byte("unencrypted"), secret.Data["test.yaml"])
+		})
+	}
+}
+
+func TestReconcile_CrossNamespaceTargetRevoked(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			Target:     &v1alpha1.SopsSecretTarget{Namespace: "other-namespace"},
+			StringData: map[string]string{"test.yaml": "encrypted"},
+		},
+	}
+	target := types.NamespacedName{Namespace: "other-namespace", Name: name}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(1)
+	otherNamespace := newAllowingNamespace("other-namespace")
+	r := newSopsSecretReconciler(s, recorder, sopsSecret, otherNamespace)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: test-secret", <-recorder.Events)
+
+	// Secrets in namespaces that no longer allow the SopsSecret are not deleted
+	otherNamespace.Annotations = nil
+	require.NoError(t, r.Update(context.Background(), otherNamespace))
+	require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+	require.NoError(t, r.Delete(context.Background(), sopsSecret))
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	assert.True(t, apierrors.IsNotFound(err))
+	secret := &corev1.Secret{}
+	require.NoError(t, r.Get(context.Background(), target, secret))
+}
+
+func TestTargetNamespaceAllowed(t *testing.T) {
+	tests := []struct {
+		name        string
+		namespace   string
+		annotations map[string]string
+		want        bool
+	}{
+		{name: "own namespace", namespace: namespace, want: true},
+		{name: "missing namespace", namespace: "missing"},
+		{name: "no annotation", namespace: "other-namespace"},
+		{
+			name:        "listed",
+			namespace:   "other-namespace",
+			annotations: map[string]string{"craftypath.github.io/allowed-source-namespaces": "team,test-namespace"},
+			want:        true,
+		},
+		{
+			name:        "not listed",
+			namespace:   "other-namespace",
+			annotations: map[string]string{"craftypath.github.io/allowed-source-namespaces": "team,test"},
+		},
+		{
+			name:        "all",
+			namespace:   "other-namespace",
+			annotations: map[string]string{"craftypath.github.io/allowed-source-namespaces": "*"},
+			want:        true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			otherNamespace := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "other-namespace", Annotations: tt.annotations}}
+			r := newSopsSecretReconciler(s, record.NewFakeRecorder(1), otherNamespace)
+			sopsSecret := &v1alpha1.SopsSecret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+
+			allowed, err := r.targetNamespaceAllowed(context.Background(), sopsSecret, tt.namespace)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, allowed)
+		})
+	}
+}
+
+func TestReconcile_Targets(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1alpha1.SopsSecretSpec{
+			Metadata: v1alpha1.SopsSecretObjectMeta{
+				Labels: map[string]string{"mylabel": "foo"},
+			},
+			StringData: map[string]string{"username": "encrypted", "password": "encrypted"},
+			Targets: []v1alpha1.SopsSecretTargetSecret{
+				{
+					Name: "app",
+				},
+				{
+					Name: "db",
+					Type: corev1.SecretTypeBasicAuth,
+					Metadata: v1alpha1.SopsSecretObjectMeta{
+						Labels: map[string]string{"mylabel": "bar"},
+					},
+					Keys: []v1alpha1.SopsSecretKeyMapping{
+						{Key: "username"},
+						{Key: "password", Name: "pass"},
+					},
+				},
+			},
+		},
+	}
+
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	recorder := record.NewFakeRecorder(2)
+	r := newSopsSecretReconciler(s, recorder, sopsSecret)
+
+	_, err := r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, "Normal Created Created secret: app", <-recorder.Events)
+	assert.Equal(t, "Normal Created Created secret: db", <-recorder.Events)
+
+	secret := &corev1.Secret{}
+	err = r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "app"}, secret)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"username": []byte("unencrypted"), "password": []byte("unencrypted")}, secret.Data)
+	assert.Equal(t, map[string]string{"mylabel": "foo"}, secret.Labels)
+
+	err = r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "db"}, secret)
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{"username": []byte("unencrypted"), "pass": []byte("unencrypted")}, secret.Data)
+	assert.Equal(t, map[string]string{"mylabel": "bar"}, secret.Labels)
+	assert.Equal(t, corev1.SecretTypeBasicAuth, secret.Type)
+
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.Nil(t, sopsSecret.Status.Target)
+	assert.Equal(t, []v1alpha1.SopsSecretTargetStatus{
+		{Name: "app", Namespace: namespace, Status: "Success"},
+		{Name: "db", Namespace: namespace, Status: "Success"},
+	}, sopsSecret.Status.Targets)
+
+	sopsSecret.Spec.Targets = sopsSecret.Spec.Targets[1:]
+	sopsSecret.Spec.Targets[0].Keys = append(sopsSecret.Spec.Targets[0].Keys, v1alpha1.SopsSecretKeyMapping{Key: "missing"})
+	err = r.Update(context.Background(), sopsSecret)
+	require.NoError(t, err)
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+	assert.Equal(t, `Warning ProcessingError Failed to update secret: key "missing" not found`, <-recorder.Events)
+
+	// Removed targets are kept until all targets are synced
+	err = r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "app"}, secret)
+	require.NoError(t, err)
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.Equal(t, []v1alpha1.SopsSecretTargetStatus{
+		{Name: "db", Namespace: namespace, Status: "Failure", Message: `failed to update secret: key "missing" not found`},
+		{Name: "app", Namespace: namespace, Status: "Success"},
+	}, sopsSecret.Status.Targets)
+
+	sopsSecret.Spec.Targets[0].Keys = sopsSecret.Spec.Targets[0].Keys[:2]
+	err = r.Update(context.Background(), sopsSecret)
+	require.NoError(t, err)
+
+	_, err = r.Reconcile(context.Background(), req)
+	require.NoError(t, err)
+
+	err = r.Get(context.Background(), types.NamespacedName{Namespace: namespace, Name: "app"}, secret)
+	assert.True(t, apierrors.IsNotFound(err))
+	err = r.Get(context.Background(), req.NamespacedName, sopsSecret)
+	require.NoError(t, err)
+	assert.Equal(t, []v1alpha1.SopsSecretTargetStatus{
+		{Name: "db", Namespace: namespace, Status: "Success"},
+	}, sopsSecret.Status.Targets)
+}
+
+func TestSelectKeys(t *testing.T) {
+	data := map[string][]byte{"a": []byte("1"), "b": []byte("2")}
+	tests := []struct {
+		name     string
+		mappings []v1alpha1.SopsSecretKeyMapping
+		want     map[string][]byte
+		wantErr  string
+	}{
+		{
+			name:     "select and rename",
+			mappings: []v1alpha1.SopsSecretKeyMapping{{Key: "a"}, {Key: "b", Name: "c"}},
+			want:     map[string][]byte{"a": []byte("1"), "c": []byte("2")},
+		},
+		{
+			name:     "missing key",
+			mappings: []v1alpha1.SopsSecretKeyMapping{{Key: "x"}},
+			wantErr:  `key "x" not found`,
+		},
+		{
+			name:     "duplicate name",
+			mappings: []v1alpha1.SopsSecretKeyMapping{{Key: "a"}, {Key: "b", Name: "a"}},
+			wantErr:  `key "a" is selected more than once`,
+		},
+		{
+			name:     "invalid name",
+			mappings: []v1alpha1.SopsSecretKeyMapping{{Key: "a", Name: "a/b"}},
+			wantErr:  `invalid key name "a/b"`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := selectKeys(data, tt.mappings)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+// failingCreateClient fails to create any object.
+type failingCreateClient struct {
+	client.Client
+}
+
+func (c *failingCreateClient) Create(context.Context, client.Object, ...client.CreateOption) error {
+	return errors.New("create failed")
+}
+
+// failingSecretUpdateClient fails to update any Secret.
+type failingSecretUpdateClient struct {
+	client.Client
+}
+
+func (c *failingSecretUpdateClient) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return errors.New("update failed")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+// newAllowingNamespace returns a namespace that allows SopsSecrets in the test namespace to generate Secrets in it.
+func newAllowingNamespace(name string) *corev1.Namespace {
+	return &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Annotations: map[string]string{"craftypath.github.io/allowed-source-namespaces": namespace},
+		},
+	}
+}
+
+func newSopsSecretReconciler(s *runtime.Scheme, recorder *record.FakeRecorder, objs ...runtime.Object) *SopsSecretReconciler {
+	cl := fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build()
+	return &SopsSecretReconciler{
+		Client:    cl,
+		Scheme:    s,
+		Recorder:  recorder,
+		Decryptor: &FakeDecryptor{},
+	}
+}
+
+func TestKeyRefIndexValues(t *testing.T) {
+	assert.Empty(t, keyRefIndexValues(nil))
+	assert.Empty(t, keyRefIndexValues(&v1alpha1.SopsSecretDecryption{}))
+	assert.Equal(t, []string{"keys"}, keyRefIndexValues(&v1alpha1.SopsSecretDecryption{
+		KeyRef: &corev1.LocalObjectReference{Name: "keys"},
+	}))
+}
+
+func TestNeedsFinalizer(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy v1alpha1.DeletionPolicy
+		target *v1alpha1.SopsSecretTarget
+		status v1alpha1.SopsSecretStatus
+		want   bool
+	}{
+		{name: "delete"},
+		{name: "orphan", policy: v1alpha1.DeletionPolicyOrphan, want: true},
+		{name: "cross-namespace target", target: &v1alpha1.SopsSecretTarget{Namespace: "other-namespace"}, want: true},
+		{
+			name:   "previous cross-namespace target",
+			status: v1alpha1.SopsSecretStatus{Target: &corev1.SecretReference{Name: name, Namespace: "other-namespace"}},
+			want:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec:       v1alpha1.SopsSecretSpec{DeletionPolicy: tt.policy, Target: tt.target},
+				Status:     tt.status,
+			}
+			assert.Equal(t, tt.want, needsFinalizer(sopsSecret, targetsFor(sopsSecret)))
+		})
 	}
 }
@@ -0,0 +1,157 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// sourceRefIndexKey indexes SopsSecrets by the objects referenced in their source refs as '<kind>/<name>'.
+const sourceRefIndexKey = ".spec.sourceRefs"
+
+// withSources returns a shallow copy of the given SopsSecret whose StringData additionally holds the
+// encrypted content referenced by its source refs, so that it is decrypted like inline entries.
+func (g *generator) withSources(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) (*craftypathgithubiov1alpha1.SopsSecret, error) {
+	if len(sopsSecret.Spec.SourceRefs) == 0 {
+		return sopsSecret, nil
+	}
+
+	stringData := make(map[string]string, len(sopsSecret.Spec.StringData)+len(sopsSecret.Spec.SourceRefs))
+	for key, value := range sopsSecret.Spec.StringData {
+		stringData[key] = value
+	}
+	for _, ref := range sopsSecret.Spec.SourceRefs {
+		name := ref.GetEntryName()
+		_, inStringData := stringData[name]
+		_, inData := sopsSecret.Spec.Data[name]
+		if inStringData || inData {
+			return nil, fmt.Errorf("entry %q of sourceRefs conflicts with another entry", name)
+		}
+
+		content, err := g.sourceContent(ctx, sopsSecret.Namespace, ref)
+		if err != nil {
+			return nil, err
+		}
+		stringData[name] = content
+	}
+
+	withSources := *sopsSecret
+	withSources.Spec.StringData = stringData
+	return &withSources, nil
+}
+
+// sourceContent returns the encrypted content referenced by the given source ref.
+func (g *generator) sourceContent(ctx context.Context, namespace string, ref craftypathgithubiov1alpha1.SopsSecretSourceRef) (string, error) {
+	if ref.Kind.IsArtifact() {
+		return g.artifactContent(ctx, namespace, ref)
+	}
+	if ref.Key == "" {
+		return "", fmt.Errorf("key must be specified for source %s %q", ref.Kind, ref.Name)
+	}
+
+	name := types.NamespacedName{Namespace: namespace, Name: ref.Name}
+	switch ref.Kind {
+	case craftypathgithubiov1alpha1.SourceKindConfigMap:
+		configMap := &corev1.ConfigMap{}
+		if err := g.Get(ctx, name, configMap); err != nil {
+			return "", sourceGetError(ref, err)
+		}
+		if value, exists := configMap.Data[ref.Key]; exists {
+			return value, nil
+		}
+		if value, exists := configMap.BinaryData[ref.Key]; exists {
+			return string(value), nil
+		}
+	case craftypathgithubiov1alpha1.SourceKindSecret:
+		secret := &corev1.Secret{}
+		if err := g.Get(ctx, name, secret); err != nil {
+			return "", sourceGetError(ref, err)
+		}
+		if value, exists := secret.Data[ref.Key]; exists {
+			return string(value), nil
+		}
+	default:
+		return "", fmt.Errorf("unsupported source kind %q", ref.Kind)
+	}
+	return "", &reasonError{
+		reason: reasonSourceNotFound,
+		err:    fmt.Errorf("key %q not found in source %s %q", ref.Key, ref.Kind, ref.Name),
+	}
+}
+
+func sourceGetError(ref craftypathgithubiov1alpha1.SopsSecretSourceRef, err error) error {
+	if apierrors.IsNotFound(err) {
+		return &reasonError{
+			reason: reasonSourceNotFound,
+			err:    fmt.Errorf("source %s %q not found", ref.Kind, ref.Name),
+		}
+	}
+	if meta.IsNoMatchError(err) {
+		return &reasonError{
+			reason: reasonSourceNotFound,
+			err:    fmt.Errorf("source kind %s is not installed", ref.Kind),
+		}
+	}
+	return fmt.Errorf("unable to get source %s %q: %w", ref.Kind, ref.Name, err)
+}
+
+// sourceRefIndexValues returns the values of the source ref index of the given SopsSecret.
+func sourceRefIndexValues(obj client.Object) []string {
+	sopsSecret := obj.(*craftypathgithubiov1alpha1.SopsSecret)
+	values := make([]string, 0, len(sopsSecret.Spec.SourceRefs))
+	for _, ref := range sopsSecret.Spec.SourceRefs {
+		values = append(values, sourceRefIndexValue(ref.Kind, ref.Name))
+	}
+	return values
+}
+
+func sourceRefIndexValue(kind craftypathgithubiov1alpha1.SourceKind, name string) string {
+	return string(kind) + "/" + name
+}
+
+// findSopsSecretsForSource returns a function mapping objects of the given kind to requests
+// for the SopsSecrets referencing them in their source refs.
+func (r *SopsSecretReconciler) findSopsSecretsForSource(kind craftypathgithubiov1alpha1.SourceKind) handler.MapFunc {
+	return func(obj client.Object) []reconcile.Request {
+		sopsSecrets := &craftypathgithubiov1alpha1.SopsSecretList{}
+		if err := r.List(context.Background(), sopsSecrets, client.InNamespace(obj.GetNamespace()),
+			client.MatchingFields{sourceRefIndexKey: sourceRefIndexValue(kind, obj.GetName())}); err != nil {
+			log.Log.Error(err, "unable to list SopsSecrets", "namespace", obj.GetNamespace())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(sopsSecrets.Items))
+		for _, sopsSecret := range sopsSecrets.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: sopsSecret.Namespace, Name: sopsSecret.Name},
+			})
+		}
+		return requests
+	}
+}
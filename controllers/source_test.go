@@ -0,0 +1,162 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func TestReconcile_SourceRefs(t *testing.T) {
+	sources := []runtime.Object{
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: namespace},
+			Data:       map[string]string{"db.yaml": "encrypted db"},
+			BinaryData: map[string][]byte{"cert.der": []byte("encrypted cert")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "shared", Namespace: namespace},
+			Data:       map[string][]byte{"env": []byte("encrypted env")},
+		},
+	}
+
+	tests := []struct {
+		name          string
+		spec          v1alpha1.SopsSecretSpec
+		wantEncrypted map[string]string
+		wantKeys      []string
+		wantEvent     string
+		wantReason    string
+	}{
+		{
+			name: "configmap and secret",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"test.yaml": "encrypted"},
+				SourceRefs: []v1alpha1.SopsSecretSourceRef{
+					{Kind: v1alpha1.SourceKindConfigMap, Name: "shared", Key: "db.yaml"},
+					{Kind: v1alpha1.SourceKindConfigMap, Name: "shared", Key: "cert.der"},
+					{Kind: v1alpha1.SourceKindSecret, Name: "shared", Key: "env", EntryName: "app.env"},
+				},
+			},
+			wantEncrypted: map[string]string{
+				"test.yaml": "encrypted",
+				"db.yaml":   "encrypted db",
+				"cert.der":  "encrypted cert",
+				"app.env":   "encrypted env",
+			},
+			wantKeys:  []string{"app.env", "cert.der", "db.yaml", "test.yaml"},
+			wantEvent: "Normal Created Created secret: test-secret",
+		},
+		{
+			name: "source not found",
+			spec: v1alpha1.SopsSecretSpec{
+				SourceRefs: []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindConfigMap, Name: "missing", Key: "db.yaml"}},
+			},
+			wantEvent:  `Warning SourceNotFound Failed to update secret: source ConfigMap "missing" not found`,
+			wantReason: reasonSourceNotFound,
+		},
+		{
+			name: "key not found",
+			spec: v1alpha1.SopsSecretSpec{
+				SourceRefs: []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindSecret, Name: "shared", Key: "db.yaml"}},
+			},
+			wantEvent:  `Warning SourceNotFound Failed to update secret: key "db.yaml" not found in source Secret "shared"`,
+			wantReason: reasonSourceNotFound,
+		},
+		{
+			name: "conflict",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"db.yaml": "encrypted"},
+				SourceRefs: []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindConfigMap, Name: "shared", Key: "db.yaml"}},
+			},
+			wantEvent:  `Warning ProcessingError Failed to update secret: entry "db.yaml" of sourceRefs conflicts with another entry`,
+			wantReason: reasonProcessingError,
+		},
+		{
+			name: "manifest",
+			spec: v1alpha1.SopsSecretSpec{
+				Manifest:   "encrypted",
+				SourceRefs: []v1alpha1.SopsSecretSourceRef{{Kind: v1alpha1.SourceKindConfigMap, Name: "shared", Key: "db.yaml"}},
+			},
+			wantEvent:  "Warning ProcessingError Failed to update secret: manifest must not be specified together with sourceRefs",
+			wantReason: reasonProcessingError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			utilruntime.Must(scheme.AddToScheme(s))
+			utilruntime.Must(v1alpha1.AddToScheme(s))
+
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+				Spec:       tt.spec,
+			}
+			recorder := record.NewFakeRecorder(1)
+			decryptor := &FakeDecryptor{}
+			r := newSopsSecretReconciler(s, recorder, append(sources, sopsSecret)...)
+			r.Decryptor = decryptor
+
+			_, err := r.Reconcile(context.Background(), req)
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantEvent, <-recorder.Events)
+
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, sopsSecret))
+			if tt.wantReason != "" {
+				condition := meta.FindStatusCondition(sopsSecret.Status.Conditions, v1alpha1.ConditionTypeReady)
+				require.NotNil(t, condition)
+				assert.Equal(t, metav1.ConditionFalse, condition.Status)
+				assert.Equal(t, tt.wantReason, condition.Reason)
+				return
+			}
+
+			assert.Equal(t, tt.wantEncrypted, decryptor.encrypted)
+			secret := &corev1.Secret{}
+			require.NoError(t, r.Get(context.Background(), req.NamespacedName, secret))
+			var keys []string
+			for _, status := range sopsSecret.Status.Keys {
+				keys = append(keys, status.Name)
+				assert.Contains(t, secret.Data, status.Name)
+			}
+			assert.Equal(t, tt.wantKeys, keys)
+		})
+	}
+}
+
+func TestSourceRefIndexValues(t *testing.T) {
+	sopsSecret := &v1alpha1.SopsSecret{
+		Spec: v1alpha1.SopsSecretSpec{
+			SourceRefs: []v1alpha1.SopsSecretSourceRef{
+				{Kind: v1alpha1.SourceKindConfigMap, Name: "shared", Key: "db.yaml"},
+				{Kind: v1alpha1.SourceKindSecret, Name: "shared", Key: "env"},
+			},
+		},
+	}
+	assert.Equal(t, []string{"ConfigMap/shared", "Secret/shared"}, sourceRefIndexValues(sopsSecret))
+}
@@ -0,0 +1,177 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+// statusObject is a SopsSecret, SopsConfigMap or ClusterSopsSecret, which report their state as conditions
+// for their observed generation.
+type statusObject interface {
+	client.Object
+	GetConditions() []metav1.Condition
+	SetConditions(conditions []metav1.Condition)
+	SetObservedGeneration(generation int64)
+	GetLastUpdate() metav1.Time
+	SetLastUpdate(lastUpdate metav1.Time)
+}
+
+// manageError records the given error as the reason of the given condition being false and requeues the object
+// with the backoff for its name.
+func manageError(ctx context.Context, c client.Client, recorder record.EventRecorder, backoff *errorBackoff, instance statusObject, conditionType string, issue error) (reconcile.Result, error) {
+	logger := log.FromContext(ctx)
+	logger.Info("handling reconciliation error")
+
+	reason := reasonFor(issue)
+	recorder.Event(instance, "Warning", reason, capitalizeFirst(issue.Error()))
+
+	wasReady := meta.IsStatusConditionTrue(instance.GetConditions(), craftypathgithubiov1alpha1.ConditionTypeReady)
+
+	instance.SetObservedGeneration(instance.GetGeneration())
+	setStatusCondition(instance, conditionType, metav1.ConditionFalse, reason, issue.Error())
+	setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeReady, metav1.ConditionFalse, reason, issue.Error())
+
+	if err := updateStatus(ctx, c, instance); err != nil {
+		logger.Error(err, "unable to update status")
+		return reconcile.Result{
+			RequeueAfter: time.Second,
+			Requeue:      true,
+		}, nil
+	}
+
+	reqeueAfter := backoff.next(client.ObjectKeyFromObject(instance), wasReady)
+	logger.Error(issue, "failed to reconcile "+kindOf(c, instance), "reqeueAfter", reqeueAfter)
+	return reconcile.Result{
+		RequeueAfter: reqeueAfter,
+		Requeue:      true,
+	}, nil
+}
+
+// manageSuccess marks the given object as synced and ready, resets its backoff and writes its status.
+// If the status cannot be written, false and a result requeuing the object are returned.
+func manageSuccess(ctx context.Context, c client.Client, recorder record.EventRecorder, backoff *errorBackoff, instance statusObject, syncedType, syncedMessage string) (reconcile.Result, bool) {
+	logger := log.FromContext(ctx)
+	logger.Info("handling reconciliation success")
+	backoff.reset(client.ObjectKeyFromObject(instance))
+
+	instance.SetObservedGeneration(instance.GetGeneration())
+	setStatusCondition(instance, syncedType, metav1.ConditionTrue, reasonReconciled, syncedMessage)
+	setStatusCondition(instance, craftypathgithubiov1alpha1.ConditionTypeReady, metav1.ConditionTrue, reasonReconciled, kindOf(c, instance)+" reconciled successfully")
+
+	if err := updateStatus(ctx, c, instance); err != nil {
+		logger.Error(err, "unable to update status")
+		recorder.Event(instance, "Warning", "ProcessingError", "Unable to update status")
+		return reconcile.Result{
+			RequeueAfter: time.Second,
+			Requeue:      true,
+		}, false
+	}
+	return reconcile.Result{}, true
+}
+
+// updateStatus writes the status of the given object if it differs from the stored status.
+// Only the status is patched, with optimistic locking, retrying on conflicts with the latest version.
+func updateStatus(ctx context.Context, c client.Client, instance statusObject) error {
+	gvk, err := apiutil.GVKForObject(instance, c.Scheme())
+	if err != nil {
+		return err
+	}
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		obj, err := c.Scheme().New(gvk)
+		if err != nil {
+			return err
+		}
+		latest := obj.(statusObject)
+		if err := c.Get(ctx, client.ObjectKeyFromObject(instance), latest); err != nil {
+			return err
+		}
+
+		instance.SetLastUpdate(latest.GetLastUpdate())
+		patch, err := statusPatch(latest, instance)
+		if err != nil || patch == nil {
+			return err
+		}
+		instance.SetLastUpdate(metav1.Now())
+		if patch, err = statusPatch(latest, instance); err != nil {
+			return err
+		}
+		return c.Status().Patch(ctx, instance, patch)
+	})
+}
+
+// statusPatch returns a merge patch of the status of the latest version of an object to the status of the
+// given object, locked to the resource version of the latest version. It returns nil if the statuses are equal.
+func statusPatch(latest, obj client.Object) (client.Patch, error) {
+	data, err := client.MergeFrom(latest).Data(obj)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute status patch: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("unable to decode status patch: %w", err)
+	}
+	status, ok := fields["status"]
+	if !ok {
+		return nil, nil
+	}
+	data, err = json.Marshal(map[string]interface{}{
+		"metadata": map[string]string{"resourceVersion": latest.GetResourceVersion()},
+		"status":   status,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode status patch: %w", err)
+	}
+	return client.RawPatch(types.MergePatchType, data), nil
+}
+
+// setStatusCondition sets the given condition of the object for its current generation.
+func setStatusCondition(obj statusObject, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	conditions := obj.GetConditions()
+	meta.SetStatusCondition(&conditions, metav1.Condition{
+		Type:               conditionType,
+		Status:             status,
+		ObservedGeneration: obj.GetGeneration(),
+		Reason:             reason,
+		Message:            message,
+	})
+	obj.SetConditions(conditions)
+}
+
+// kindOf returns the kind of the given object.
+func kindOf(c client.Client, obj client.Object) string {
+	gvk, err := apiutil.GVKForObject(obj, c.Scheme())
+	if err != nil {
+		return "object"
+	}
+	return gvk.Kind
+}
@@ -0,0 +1,72 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func TestUpdateStatus(t *testing.T) {
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+
+	sopsConfigMap := &v1alpha1.SopsConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace, Generation: 1},
+		Spec:       v1alpha1.SopsConfigMapSpec{StringData: map[string]string{"test.yaml": "encrypted"}},
+	}
+	cl := fake.NewClientBuilder().WithScheme(s).WithObjects(sopsConfigMap).Build()
+	ctx := context.Background()
+
+	instance := &v1alpha1.SopsConfigMap{}
+	require.NoError(t, cl.Get(ctx, req.NamespacedName, instance))
+	setStatusCondition(instance, v1alpha1.ConditionTypeReady, metav1.ConditionTrue, reasonReconciled, "Reconciled")
+	require.NoError(t, updateStatus(ctx, cl, instance))
+	require.False(t, instance.Status.LastUpdate.IsZero())
+	lastUpdate := instance.Status.LastUpdate
+	resourceVersion := instance.ResourceVersion
+
+	// an unchanged status is not written
+	setStatusCondition(instance, v1alpha1.ConditionTypeReady, metav1.ConditionTrue, reasonReconciled, "Reconciled")
+	require.NoError(t, updateStatus(ctx, cl, instance))
+	latest := &v1alpha1.SopsConfigMap{}
+	require.NoError(t, cl.Get(ctx, req.NamespacedName, latest))
+	assert.Equal(t, resourceVersion, latest.ResourceVersion)
+	assert.True(t, lastUpdate.Equal(&latest.Status.LastUpdate))
+
+	// only the status is written, so that a concurrent change of the spec is kept
+	latest.Spec.StringData = map[string]string{"test.yaml": "changed"}
+	require.NoError(t, cl.Update(ctx, latest))
+	setStatusCondition(instance, v1alpha1.ConditionTypeReady, metav1.ConditionFalse, reasonProcessingError, "Failed")
+	require.NoError(t, updateStatus(ctx, cl, instance))
+	require.NoError(t, cl.Get(ctx, client.ObjectKeyFromObject(instance), latest))
+	assert.Equal(t, "changed", latest.Spec.StringData["test.yaml"])
+	assert.True(t, meta.IsStatusConditionFalse(latest.Status.Conditions, v1alpha1.ConditionTypeReady))
+}
@@ -0,0 +1,306 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+const (
+	// managedByLabel marks Secrets generated by the operator that are tracked via labels instead of owner references.
+	managedByLabel = "app.kubernetes.io/managed-by"
+	managedByValue = "sops-operator"
+	// ownerNameLabel and ownerNamespaceLabel identify the SopsSecret owning a Secret tracked via labels.
+	ownerNameLabel      = "craftypath.github.io/sopssecret-name"
+	ownerNamespaceLabel = "craftypath.github.io/sopssecret-namespace"
+)
+
+// generatedTarget is a Secret generated from a SopsSecret.
+type generatedTarget struct {
+	ref corev1.SecretReference
+	// spec is the target's entry in the SopsSecret's targets, or nil for the SopsSecret's single target.
+	spec *craftypathgithubiov1alpha1.SopsSecretTargetSecret
+}
+
+// targetsFor returns the Secrets to generate for the given SopsSecret.
+func targetsFor(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) []generatedTarget {
+	if len(sopsSecret.Spec.Targets) == 0 {
+		return []generatedTarget{{ref: targetFor(sopsSecret)}}
+	}
+
+	targets := make([]generatedTarget, 0, len(sopsSecret.Spec.Targets))
+	for i := range sopsSecret.Spec.Targets {
+		spec := &sopsSecret.Spec.Targets[i]
+		ref := corev1.SecretReference{
+			Name:      spec.Name,
+			Namespace: spec.Namespace,
+		}
+		if ref.Namespace == "" {
+			ref.Namespace = sopsSecret.Namespace
+		}
+		targets = append(targets, generatedTarget{ref: ref, spec: spec})
+	}
+	return targets
+}
+
+// targetFor returns the single Secret to generate for the given SopsSecret without targets.
+func targetFor(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) corev1.SecretReference {
+	target := corev1.SecretReference{
+		Name:      sopsSecret.Name,
+		Namespace: sopsSecret.Namespace,
+	}
+	if sopsSecret.Spec.Target != nil {
+		if sopsSecret.Spec.Target.Name != "" {
+			target.Name = sopsSecret.Spec.Target.Name
+		}
+		if sopsSecret.Spec.Target.Namespace != "" {
+			target.Namespace = sopsSecret.Spec.Target.Namespace
+		}
+	}
+	return target
+}
+
+// allowedSourceNamespacesAnnotation is the annotation of a namespace listing the namespaces, separated by
+// commas, whose SopsSecrets may generate Secrets in it. The value "*" allows all namespaces.
+const allowedSourceNamespacesAnnotation = "craftypath.github.io/allowed-source-namespaces"
+
+// validateTargets checks that the given targets of the SopsSecret can be generated.
+func (r *SopsSecretReconciler) validateTargets(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, targets []generatedTarget) error {
+	if sopsSecret.Spec.Target != nil && len(sopsSecret.Spec.Targets) > 0 {
+		return fmt.Errorf("target must not be specified together with targets")
+	}
+
+	seen := make(map[corev1.SecretReference]bool, len(targets))
+	for _, target := range targets {
+		if seen[target.ref] {
+			return fmt.Errorf("target %s/%s is specified more than once", target.ref.Namespace, target.ref.Name)
+		}
+		seen[target.ref] = true
+
+		allowed, err := r.targetNamespaceAllowed(ctx, sopsSecret, target.ref.Namespace)
+		if err != nil {
+			return err
+		}
+		if !allowed {
+			return &reasonError{
+				reason: reasonTargetNotAllowed,
+				err: fmt.Errorf("target namespace %q is not allowed, its annotation %s does not list namespace %q",
+					target.ref.Namespace, allowedSourceNamespacesAnnotation, sopsSecret.Namespace),
+			}
+		}
+	}
+	return nil
+}
+
+// targetNamespaceAllowed returns whether the given SopsSecret may generate and clean up Secrets in the given
+// namespace. Namespaces other than the SopsSecret's own must opt in with allowedSourceNamespacesAnnotation.
+func (r *SopsSecretReconciler) targetNamespaceAllowed(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, namespace string) (bool, error) {
+	return r.namespaceAllows(ctx, namespace, allowedSourceNamespacesAnnotation, sopsSecret.Namespace)
+}
+
+// namespaceAllows returns whether the given namespace lists the source namespace in the given annotation.
+// A namespace always allows itself, deleted namespaces allow nothing.
+func (r *SopsSecretReconciler) namespaceAllows(ctx context.Context, namespace string, annotation string, source string) (bool, error) {
+	if namespace == source {
+		return true, nil
+	}
+	ns := &corev1.Namespace{}
+	if err := r.Get(ctx, types.NamespacedName{Name: namespace}, ns); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to get namespace %q: %w", namespace, err)
+	}
+	for _, allowed := range strings.Split(ns.Annotations[annotation], ",") {
+		allowed = strings.TrimSpace(allowed)
+		if allowed == "*" || allowed == source {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// previousTargetsFor returns the Secrets last generated for the given SopsSecret as recorded in its status.
+// SopsSecrets without recorded targets have generated a Secret with their own name and namespace.
+func previousTargetsFor(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) []corev1.SecretReference {
+	var previous []corev1.SecretReference
+	if sopsSecret.Status.Target != nil {
+		previous = append(previous, *sopsSecret.Status.Target)
+	}
+	for _, status := range sopsSecret.Status.Targets {
+		previous = append(previous, corev1.SecretReference{Name: status.Name, Namespace: status.Namespace})
+	}
+	if len(previous) == 0 {
+		previous = append(previous, corev1.SecretReference{
+			Name:      sopsSecret.Name,
+			Namespace: sopsSecret.Namespace,
+		})
+	}
+	return previous
+}
+
+// removedTargetStatuses returns the recorded statuses of Secrets that are no longer targets of the given SopsSecret.
+func removedTargetStatuses(sopsSecret *craftypathgithubiov1alpha1.SopsSecret, targets []generatedTarget) []craftypathgithubiov1alpha1.SopsSecretTargetStatus {
+	var removed []craftypathgithubiov1alpha1.SopsSecretTargetStatus
+	for _, status := range sopsSecret.Status.Targets {
+		if !containsTarget(targets, corev1.SecretReference{Name: status.Name, Namespace: status.Namespace}) {
+			removed = append(removed, status)
+		}
+	}
+	return removed
+}
+
+func containsTarget(targets []generatedTarget, ref corev1.SecretReference) bool {
+	for _, target := range targets {
+		if target.ref == ref {
+			return true
+		}
+	}
+	return false
+}
+
+// previousData returns the current data of the target Secrets keyed by the entries they were selected from.
+// Since all targets are generated from the same entries, the first value found for an entry is used.
+func (r *SopsSecretReconciler) previousData(ctx context.Context, targets []generatedTarget) map[string][]byte {
+	previous := make(map[string][]byte)
+	add := func(key string, value []byte, exists bool) {
+		if _, seen := previous[key]; exists && !seen {
+			previous[key] = value
+		}
+	}
+	for _, target := range targets {
+		secret := &corev1.Secret{}
+		if err := r.Get(ctx, types.NamespacedName{Namespace: target.ref.Namespace, Name: target.ref.Name}, secret); err != nil {
+			continue
+		}
+		if target.spec == nil || len(target.spec.Keys) == 0 {
+			for key, value := range secret.Data {
+				add(key, value, true)
+			}
+			continue
+		}
+		for _, mapping := range target.spec.Keys {
+			value, exists := secret.Data[keyMappingName(mapping)]
+			add(mapping.Key, value, exists)
+		}
+	}
+	return previous
+}
+
+// selectKeys returns the entries of data selected by the given key mappings.
+func selectKeys(data map[string][]byte, mappings []craftypathgithubiov1alpha1.SopsSecretKeyMapping) (map[string][]byte, error) {
+	selected := make(map[string][]byte, len(mappings))
+	for _, mapping := range mappings {
+		value, exists := data[mapping.Key]
+		if !exists {
+			return nil, fmt.Errorf("key %q not found", mapping.Key)
+		}
+		name := keyMappingName(mapping)
+		if errs := validation.IsConfigMapKey(name); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid key name %q: %s", name, strings.Join(errs, ", "))
+		}
+		if _, exists := selected[name]; exists {
+			return nil, fmt.Errorf("key %q is selected more than once", name)
+		}
+		selected[name] = value
+	}
+	return selected, nil
+}
+
+func keyMappingName(mapping craftypathgithubiov1alpha1.SopsSecretKeyMapping) string {
+	if mapping.Name != "" {
+		return mapping.Name
+	}
+	return mapping.Key
+}
+
+// isOwnedBy returns whether the given Secret was generated for the given SopsSecret. Secrets in the
+// SopsSecret's namespace are owned via controller reference, Secrets in other namespaces via labels.
+func isOwnedBy(secret *corev1.Secret, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) bool {
+	if secret.Namespace == sopsSecret.Namespace {
+		return metav1.IsControlledBy(secret, sopsSecret)
+	}
+	return secret.Labels[managedByLabel] == managedByValue &&
+		secret.Labels[ownerNameLabel] == sopsSecret.Name &&
+		secret.Labels[ownerNamespaceLabel] == sopsSecret.Namespace
+}
+
+// setOwner marks the given Secret as owned by the given SopsSecret.
+func (r *SopsSecretReconciler) setOwner(secret *corev1.Secret, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) error {
+	if secret.Namespace == sopsSecret.Namespace {
+		if err := ctrl.SetControllerReference(sopsSecret, secret, r.Scheme); err != nil {
+			return fmt.Errorf("unable to set ownerReference: %w", err)
+		}
+		return nil
+	}
+
+	// Owner references cannot cross namespaces
+	labels := make(map[string]string, len(secret.Labels)+3)
+	for key, value := range secret.Labels {
+		labels[key] = value
+	}
+	labels[managedByLabel] = managedByValue
+	labels[ownerNameLabel] = sopsSecret.Name
+	labels[ownerNamespaceLabel] = sopsSecret.Namespace
+	secret.Labels = labels
+	return nil
+}
+
+// deleteTarget deletes the given Secret if it is owned by the given SopsSecret.
+func (r *SopsSecretReconciler) deleteTarget(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret, target corev1.SecretReference) error {
+	secret := &corev1.Secret{}
+	if err := r.Get(ctx, types.NamespacedName{Namespace: target.Namespace, Name: target.Name}, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("unable to get secret %s/%s: %w", target.Namespace, target.Name, err)
+	}
+	if !isOwnedBy(secret, sopsSecret) {
+		return nil
+	}
+
+	log.FromContext(ctx).Info("deleting secret", "secret", target)
+	if err := r.Delete(ctx, secret); client.IgnoreNotFound(err) != nil {
+		return fmt.Errorf("unable to delete secret %s/%s: %w", target.Namespace, target.Name, err)
+	}
+	return nil
+}
+
+// findSopsSecretForTargetSecret returns a request for the SopsSecret owning the given Secret via labels.
+func (r *SopsSecretReconciler) findSopsSecretForTargetSecret(obj client.Object) []reconcile.Request {
+	labels := obj.GetLabels()
+	if labels[managedByLabel] != managedByValue || labels[ownerNameLabel] == "" || labels[ownerNamespaceLabel] == "" {
+		return nil
+	}
+	return []reconcile.Request{{
+		NamespacedName: types.NamespacedName{Namespace: labels[ownerNamespaceLabel], Name: labels[ownerNameLabel]},
+	}}
+}
@@ -0,0 +1,157 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"golang.org/x/crypto/bcrypt"
+	"sigs.k8s.io/yaml"
+
+	"github.com/craftypath/sops-operator/pkg/sops"
+)
+
+var bcryptHashPattern = regexp.MustCompile(`\$2[aby]?\$\d\d\$[./A-Za-z0-9]{53}`)
+
+// templateData is the data templates are rendered with.
+type templateData struct {
+	// Data holds the decrypted entries parsed according to their format. Binary entries are strings.
+	Data map[string]interface{}
+	// Raw holds the decrypted entries verbatim.
+	Raw map[string]string
+}
+
+// renderTemplates renders the given templates with the decrypted entries, parsed according to
+// the given formats, as input. The previous
+// data of the Secret is used to keep the output of non-deterministic functions such as bcrypt stable.
+// Errors never contain decrypted values.
+func renderTemplates(templates map[string]string, decrypted map[string][]byte, formats map[string]string, previous map[string][]byte) (map[string][]byte, error) {
+	input := templateData{
+		Data: make(map[string]interface{}, len(decrypted)),
+		Raw:  make(map[string]string, len(decrypted)),
+	}
+	for fileName, contents := range decrypted {
+		input.Raw[fileName] = string(contents)
+
+		format := formats[fileName]
+		if format == "binary" {
+			input.Data[fileName] = string(contents)
+			continue
+		}
+		doc, err := sops.ParseDocument(format, contents)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %q as %s for templates", fileName, format)
+		}
+		input.Data[fileName] = doc
+	}
+
+	rendered := make(map[string][]byte, len(templates))
+	for _, key := range sortedStringKeys(templates) {
+		tmpl, err := template.New(key).
+			Option("missingkey=error").
+			Funcs(templateFuncs(previous[key])).
+			Parse(templates[key])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse template %q: %w", key, err)
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, input); err != nil {
+			return nil, fmt.Errorf("unable to render template %q: %w", key, err)
+		}
+		rendered[key] = buf.Bytes()
+	}
+	return rendered, nil
+}
+
+// templateFuncs returns the functions available in templates. Errors returned by
+// these functions must not contain their arguments, which may be decrypted values.
+func templateFuncs(previous []byte) template.FuncMap {
+	return template.FuncMap{
+		"b64enc": func(s string) string {
+			return base64.StdEncoding.EncodeToString([]byte(s))
+		},
+		"b64dec": func(s string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return "", errors.New("invalid base64 input")
+			}
+			return string(decoded), nil
+		},
+		"sha256sum": func(s string) string {
+			sum := sha256.Sum256([]byte(s))
+			return hex.EncodeToString(sum[:])
+		},
+		"bcrypt": func(s string) (string, error) {
+			// bcrypt hashes are salted randomly, so a hash from the previous data
+			// is reused if it still matches in order not to change the Secret
+			for _, hash := range bcryptHashPattern.FindAll(previous, -1) {
+				if bcrypt.CompareHashAndPassword(hash, []byte(s)) == nil {
+					return string(hash), nil
+				}
+			}
+			hash, err := bcrypt.GenerateFromPassword([]byte(s), bcrypt.DefaultCost)
+			if err != nil {
+				return "", errors.New("unable to generate bcrypt hash")
+			}
+			return string(hash), nil
+		},
+		"toJson": func(v interface{}) (string, error) {
+			out, err := json.Marshal(v)
+			if err != nil {
+				return "", errors.New("unable to convert value to JSON")
+			}
+			return string(out), nil
+		},
+		"toYaml": func(v interface{}) (string, error) {
+			out, err := yaml.Marshal(v)
+			if err != nil {
+				return "", errors.New("unable to convert value to YAML")
+			}
+			return strings.TrimSuffix(string(out), "\n"), nil
+		},
+		"indent": func(spaces int, s string) string {
+			pad := strings.Repeat(" ", spaces)
+			return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+		},
+		"nindent": func(spaces int, s string) string {
+			pad := strings.Repeat(" ", spaces)
+			return "\n" + pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+		},
+		"quote": strconv.Quote,
+	}
+}
+
+func sortedStringKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
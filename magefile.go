@@ -117,6 +117,9 @@ func ControllerGen() error {
 	if err := sh.RunV("controller-gen", "crd", "object:headerFile=hack/boilerplate.go.txt"); err != nil {
 		return err
 	}
+	if err := sh.RunV("controller-gen", "webhook", "paths=./...", "output:webhook:artifacts:config=config/webhook"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -17,6 +17,7 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
@@ -28,6 +29,7 @@ import (
 	uzap "go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 
@@ -36,11 +38,14 @@ import (
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	"github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/api/v1beta1"
 	"github.com/craftypath/sops-operator/controllers"
+	"github.com/craftypath/sops-operator/webhooks"
 	//+kubebuilder:scaffold:imports
 )
 
@@ -49,16 +54,24 @@ var (
 	setupLog = ctrl.Log.WithName("setup")
 )
 
-const controllerName string = "sopssecret-controller"
+const (
+	controllerName          string = "sopssecret-controller"
+	configMapControllerName string = "sopsconfigmap-controller"
+	clusterControllerName   string = "clustersopssecret-controller"
+)
 
 // watchNamespaceEnvVar is the constant for env variable WATCH_NAMESPACE
 // which specifies the namespaces (comma-separated) to watch.
 // An empty value means the operator is running with cluster scope.
 const watchNamespaceEnvVar = "WATCH_NAMESPACE"
 
+// serviceAccountNamespaceFile holds the namespace the operator is running in.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	utilruntime.Must(v1beta1.AddToScheme(scheme))
 	//+kubebuilder:scaffold:scheme
 }
 
@@ -66,11 +79,35 @@ func main() {
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var decryptorName string
+	var minRolloutInterval time.Duration
+	var refreshInterval time.Duration
+	var enableWebhooks bool
+	var decryptionCheckTimeout time.Duration
+	var decryptionCheckFailurePolicy string
+	var hashKeySecret string
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&decryptorName, "decryptor", "exec",
+		"The decryptor to use. 'exec' runs the sops binary, 'native' decrypts in-process using the SOPS Go library.")
+	flag.DurationVar(&minRolloutInterval, "min-rollout-interval", time.Minute,
+		"The minimum time between two rollouts of the workloads of a SopsSecret.")
+	flag.DurationVar(&refreshInterval, "refresh-interval", 0,
+		"The default interval at which SopsSecrets are decrypted again to verify that their keys are still usable. "+
+			"SopsSecrets can override it with spec.refreshInterval. Zero disables periodic refreshes.")
+	flag.StringVar(&hashKeySecret, "hash-key-secret", "sops-operator-hash-key",
+		"The Secret holding the key the hashes in the status of SopsSecrets are keyed with, as '<name>' in the operator's namespace "+
+			"or '<namespace>/<name>'. It is created with a random key if it does not exist.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"Serve webhooks on port 9443: the conversion webhook required for serving v1beta1 SopsSecrets "+
+			"and the webhook rejecting SopsSecrets that are not encrypted.")
+	flag.DurationVar(&decryptionCheckTimeout, "decryption-check-timeout", 5*time.Second,
+		"The time the admission-time decryption check may take. It should be shorter than the webhook's timeoutSeconds.")
+	flag.StringVar(&decryptionCheckFailurePolicy, "decryption-check-failure-policy", "Fail",
+		"Whether SopsSecrets are denied ('Fail') or allowed ('Ignore') if the admission-time decryption check cannot be completed, e.g. on timeout.")
 
 	logConfig := uzap.NewProductionEncoderConfig()
 	logConfig.EncodeTime = func(ts time.Time, encoder zapcore.PrimitiveArrayEncoder) {
@@ -110,21 +147,84 @@ func main() {
 		options.NewCache = cache.MultiNamespacedCacheBuilder(strings.Split(watchNamespace, ","))
 	}
 
+	if decryptionCheckFailurePolicy != "Fail" && decryptionCheckFailurePolicy != "Ignore" {
+		setupLog.Error(fmt.Errorf("unknown decryption check failure policy %q, must be one of: Fail, Ignore", decryptionCheckFailurePolicy), "invalid flags")
+		os.Exit(1)
+	}
+
+	decryptor, err := newDecryptor(decryptorName)
+	if err != nil {
+		setupLog.Error(err, "unable to create decryptor")
+		os.Exit(1)
+	}
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), options)
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	hashKey, err := loadHashKey(mgr, hashKeySecret)
+	if err != nil {
+		setupLog.Error(err, "unable to load hash key")
+		os.Exit(1)
+	}
+
 	if err = (&controllers.SopsSecretReconciler{
+		Client:             mgr.GetClient(),
+		Scheme:             mgr.GetScheme(),
+		Recorder:           mgr.GetEventRecorderFor(controllerName),
+		Decryptor:          decryptor,
+		MinRolloutInterval: minRolloutInterval,
+		RefreshInterval:    refreshInterval,
+		HashKey:            hashKey,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "SopsSecret")
+		os.Exit(1)
+	}
+	if err = (&controllers.SopsConfigMapReconciler{
 		Client:    mgr.GetClient(),
 		Scheme:    mgr.GetScheme(),
-		Recorder:  mgr.GetEventRecorderFor(controllerName),
-		Decryptor: &sops.Decryptor{},
+		Recorder:  mgr.GetEventRecorderFor(configMapControllerName),
+		Decryptor: decryptor,
+		HashKey:   hashKey,
 	}).SetupWithManager(mgr); err != nil {
-		setupLog.Error(err, "unable to create controller", "controller", "SopsSecret")
+		setupLog.Error(err, "unable to create controller", "controller", "SopsConfigMap")
+		os.Exit(1)
+	}
+	// ClusterSopsSecrets select namespaces across the cluster, which a cache restricted to the watched
+	// namespaces cannot list, so they are only reconciled when all namespaces are watched.
+	if watchNamespace != "" {
+		setupLog.Info("not reconciling ClusterSopsSecrets, since the manager does not watch all namespaces", "namespaces", watchNamespace)
+	} else if err = (&controllers.ClusterSopsSecretReconciler{
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorderFor(clusterControllerName),
+		Decryptor: decryptor,
+		HashKey:   hashKey,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "ClusterSopsSecret")
 		os.Exit(1)
 	}
+	if enableWebhooks {
+		if err = (&v1alpha1.SopsSecret{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SopsSecret")
+			os.Exit(1)
+		}
+		if err = (&webhooks.SopsSecretValidator{}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SopsSecretValidator")
+			os.Exit(1)
+		}
+		if err = (&webhooks.SopsSecretDecryptionChecker{
+			Reader:    mgr.GetAPIReader(),
+			Decryptor: decryptor,
+			Timeout:   decryptionCheckTimeout,
+			FailOpen:  decryptionCheckFailurePolicy == "Ignore",
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "SopsSecretDecryptionChecker")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddReadyzCheck("readyz", healthz.Ping); err != nil {
@@ -139,6 +239,41 @@ func main() {
 	}
 }
 
+// newDecryptor returns the Decryptor implementation for the given name
+func newDecryptor(name string) (controllers.Decryptor, error) {
+	switch name {
+	case "exec":
+		return &sops.Decryptor{}, nil
+	case "native":
+		return &sops.NativeDecryptor{}, nil
+	default:
+		return nil, fmt.Errorf("unknown decryptor %q, must be one of: exec, native", name)
+	}
+}
+
+// loadHashKey loads the hash key from the given Secret, which is either '<name>' in the operator's
+// namespace or '<namespace>/<name>'. The manager's cache is not started yet, so an uncached client is used.
+func loadHashKey(mgr ctrl.Manager, secret string) ([]byte, error) {
+	ref := types.NamespacedName{Name: secret}
+	if i := strings.Index(secret, "/"); i >= 0 {
+		ref.Namespace, ref.Name = secret[:i], secret[i+1:]
+	} else {
+		namespace, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to determine the operator's namespace, the hash key secret must be given as '<namespace>/<name>': %w", err)
+		}
+		ref.Namespace = strings.TrimSpace(string(namespace))
+	}
+
+	c, err := client.New(mgr.GetConfig(), client.Options{Scheme: mgr.GetScheme(), Mapper: mgr.GetRESTMapper()})
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	return controllers.LoadHashKey(ctx, c, ref)
+}
+
 // getWatchNamespace returns the Namespace the operator should be watching for changes
 func getWatchNamespace() (string, error) {
 	ns, found := os.LookupEnv(watchNamespaceEnvVar)
@@ -0,0 +1,94 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gopkg.in/ini.v1"
+	"sigs.k8s.io/yaml"
+)
+
+// ParseDocument parses a decrypted document of the given format (yaml, json, dotenv, ini).
+// Numbers in yaml and json documents are returned as json.Number in order to preserve their
+// exact representation. The sections of ini documents are returned as nested maps, with keys
+// of the default section at the top level. Errors do not contain parts of the document, since
+// it holds decrypted data.
+func ParseDocument(format string, data []byte) (map[string]interface{}, error) {
+	switch format {
+	case "yaml", "json":
+		doc := map[string]interface{}{}
+		if err := yaml.Unmarshal(data, &doc, func(d *json.Decoder) *json.Decoder {
+			d.UseNumber()
+			return d
+		}); err != nil {
+			return nil, fmt.Errorf("unable to parse decrypted %s document", format)
+		}
+		return doc, nil
+	case "dotenv":
+		return parseDotenv(data)
+	case "ini":
+		return parseINI(data)
+	default:
+		return nil, fmt.Errorf("documents of format %s cannot be parsed", format)
+	}
+}
+
+func parseDotenv(data []byte) (map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		pos := strings.Index(line, "=")
+		if pos == -1 {
+			return nil, fmt.Errorf("unable to parse decrypted dotenv document: invalid line %d", lineNumber)
+		}
+		doc[line[:pos]] = line[pos+1:]
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("unable to parse decrypted dotenv document")
+	}
+	return doc, nil
+}
+
+func parseINI(data []byte) (map[string]interface{}, error) {
+	file, err := ini.Load(data)
+	if err != nil {
+		return nil, errors.New("unable to parse decrypted ini document")
+	}
+
+	doc := map[string]interface{}{}
+	for _, section := range file.Sections() {
+		values := doc
+		if section.Name() != ini.DefaultSection {
+			values = map[string]interface{}{}
+			doc[section.Name()] = values
+		}
+		for _, key := range section.Keys() {
+			values[key.Name()] = key.Value()
+		}
+	}
+	return doc, nil
+}
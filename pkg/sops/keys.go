@@ -0,0 +1,196 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	pgparmor "github.com/ProtonMail/go-crypto/openpgp/armor"
+	"go.mozilla.org/sops/v3/keyservice"
+	"google.golang.org/grpc"
+)
+
+const (
+	// AgeKeySuffix is the suffix of keys in a key Secret that hold age identities.
+	AgeKeySuffix = ".agekey"
+	// PGPKeySuffix is the suffix of keys in a key Secret that hold armored PGP private keys.
+	PGPKeySuffix = ".asc"
+)
+
+// Keys holds private key material used for decryption instead of the keys available in the operator's environment.
+type Keys struct {
+	// AgeIdentities holds age identities in the format of an age key file.
+	AgeIdentities []byte
+	// PGPKeys holds armored PGP private keys.
+	PGPKeys [][]byte
+}
+
+// KeysFromSecretData reads private keys from the data of a Secret. Entries with the suffix
+// '.agekey' are read as age identities, entries with the suffix '.asc' as armored PGP private keys.
+// Other entries are ignored.
+func KeysFromSecretData(data map[string][]byte) (*Keys, error) {
+	names := make([]string, 0, len(data))
+	for name := range data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := &Keys{}
+	for _, name := range names {
+		switch {
+		case strings.HasSuffix(name, AgeKeySuffix):
+			if _, err := age.ParseIdentities(bytes.NewReader(data[name])); err != nil {
+				return nil, fmt.Errorf("invalid age identities in %q: %w", name, err)
+			}
+			keys.AgeIdentities = append(keys.AgeIdentities, data[name]...)
+			keys.AgeIdentities = append(keys.AgeIdentities, '\n')
+		case strings.HasSuffix(name, PGPKeySuffix):
+			if _, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data[name])); err != nil {
+				return nil, fmt.Errorf("invalid PGP key in %q: %w", name, err)
+			}
+			keys.PGPKeys = append(keys.PGPKeys, data[name])
+		}
+	}
+
+	if len(keys.AgeIdentities) == 0 && len(keys.PGPKeys) == 0 {
+		return nil, fmt.Errorf("no keys with suffix %q or %q found", AgeKeySuffix, PGPKeySuffix)
+	}
+	return keys, nil
+}
+
+// environ writes the keys to the given directory and returns the environment
+// variables that make the sops binary use them exclusively. The environment of the
+// operator is not passed on, so that its own keys cannot decrypt the data.
+func (k *Keys) environ(dir string) ([]string, error) {
+	ageKeyFile := filepath.Join(dir, "keys.txt")
+	if err := os.WriteFile(ageKeyFile, k.AgeIdentities, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write age identities: %w", err)
+	}
+
+	gnupgHome := filepath.Join(dir, "gnupg")
+	if err := os.Mkdir(gnupgHome, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create GnuPG home: %w", err)
+	}
+	env := []string{"PATH=" + os.Getenv("PATH"), "SOPS_AGE_KEY_FILE=" + ageKeyFile, "GNUPGHOME=" + gnupgHome}
+
+	for _, key := range k.PGPKeys {
+		command := exec.Command("gpg", "--batch", "--import")
+		command.Env = env
+		command.Stdin = bytes.NewReader(key)
+		if output, err := command.CombinedOutput(); err != nil {
+			return nil, fmt.Errorf("failed to import PGP key: %s", string(output))
+		}
+	}
+	return env, nil
+}
+
+// removeDir stops the GnuPG agent started for the PGP keys written to the given directory by environ
+// and removes the directory.
+func (k *Keys) removeDir(dir string) {
+	if len(k.PGPKeys) > 0 {
+		command := exec.Command("gpgconf", "--kill", "gpg-agent")
+		command.Env = []string{"PATH=" + os.Getenv("PATH"), "GNUPGHOME=" + filepath.Join(dir, "gnupg")}
+		if output, err := command.CombinedOutput(); err != nil {
+			log.Error(err, "unable to stop GnuPG agent", "output", string(output))
+		}
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Error(err, "unable to remove key directory")
+	}
+}
+
+// keyServiceClient is a sops key service client that decrypts age and PGP
+// data keys with the given Keys only. Other key types, such as cloud KMS keys
+// available to the operator, are not decrypted.
+type keyServiceClient struct {
+	keys *Keys
+}
+
+func newKeyServiceClient(keys *Keys) keyservice.KeyServiceClient {
+	return &keyServiceClient{keys: keys}
+}
+
+func (c *keyServiceClient) Encrypt(ctx context.Context, req *keyservice.EncryptRequest, opts ...grpc.CallOption) (*keyservice.EncryptResponse, error) {
+	return nil, errors.New("encryption is not supported")
+}
+
+func (c *keyServiceClient) Decrypt(ctx context.Context, req *keyservice.DecryptRequest, opts ...grpc.CallOption) (*keyservice.DecryptResponse, error) {
+	var plaintext []byte
+	var err error
+	switch req.Key.KeyType.(type) {
+	case *keyservice.Key_AgeKey:
+		plaintext, err = c.decryptAge(req.Ciphertext)
+	case *keyservice.Key_PgpKey:
+		plaintext, err = c.decryptPGP(req.Ciphertext)
+	default:
+		return nil, errors.New("only age and PGP keys can be decrypted with the referenced keys")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &keyservice.DecryptResponse{Plaintext: plaintext}, nil
+}
+
+func (c *keyServiceClient) decryptAge(ciphertext []byte) ([]byte, error) {
+	if len(c.keys.AgeIdentities) == 0 {
+		return nil, errors.New("no age identities provided")
+	}
+	identities, err := age.ParseIdentities(bytes.NewReader(c.keys.AgeIdentities))
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(ciphertext)), identities...)
+	if err != nil {
+		return nil, errors.New("no provided age identity could decrypt the data")
+	}
+	return io.ReadAll(r)
+}
+
+func (c *keyServiceClient) decryptPGP(ciphertext []byte) ([]byte, error) {
+	if len(c.keys.PGPKeys) == 0 {
+		return nil, errors.New("no PGP keys provided")
+	}
+	var ring openpgp.EntityList
+	for _, key := range c.keys.PGPKeys {
+		entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+		if err != nil {
+			return nil, err
+		}
+		ring = append(ring, entities...)
+	}
+	block, err := pgparmor.Decode(bytes.NewReader(ciphertext))
+	if err != nil {
+		return nil, fmt.Errorf("armor decoding failed: %w", err)
+	}
+	md, err := openpgp.ReadMessage(block.Body, ring, nil, nil)
+	if err != nil {
+		return nil, fmt.Errorf("no provided PGP key could decrypt the data: %w", err)
+	}
+	return io.ReadAll(md.UnverifiedBody)
+}
@@ -0,0 +1,106 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	gosops "go.mozilla.org/sops/v3"
+	"go.mozilla.org/sops/v3/aes"
+	"go.mozilla.org/sops/v3/keyservice"
+	"go.mozilla.org/sops/v3/stores/dotenv"
+	"go.mozilla.org/sops/v3/stores/ini"
+	"go.mozilla.org/sops/v3/stores/json"
+	"go.mozilla.org/sops/v3/stores/yaml"
+)
+
+// NativeDecryptor decrypts data in-process using the SOPS Go library instead of
+// running the sops binary.
+type NativeDecryptor struct{}
+
+// Decrypt decrypts the given encrypted string. The format (yaml, json, dotenv, init, binary)
+// is determined by the given fileName. If keys are given, age and PGP data keys are decrypted
+// with those keys only.
+func (d *NativeDecryptor) Decrypt(fileName string, encrypted string, keys *Keys) ([]byte, error) {
+	format := FileFormat(fileName)
+	log.V(1).Info("decrypting in-process", "format", format)
+
+	store := storeForFormat(format)
+	tree, err := store.LoadEncryptedFile([]byte(encrypted))
+	if err != nil {
+		if errors.Is(err, gosops.MetadataNotFound) {
+			return nil, fmt.Errorf("failed to decrypt file: sops metadata not found")
+		}
+		return nil, fmt.Errorf("failed to decrypt file: error loading file: %w", err)
+	}
+
+	// The keys available to the operator are only used if no keys are given
+	var svc keyservice.KeyServiceClient
+	if keys != nil {
+		svc = newKeyServiceClient(keys)
+	} else {
+		svc = keyservice.NewLocalClient()
+	}
+	svcs := []keyservice.KeyServiceClient{svc}
+	dataKey, err := tree.Metadata.GetDataKeyWithKeyServices(svcs)
+	if err != nil {
+		// The sops CLI prints the detailed per-key report of UserError on stderr,
+		// so we do the same in order to keep error messages informative.
+		var userErr gosops.UserError
+		if errors.As(err, &userErr) {
+			return nil, fmt.Errorf("failed to decrypt file: %s", userErr.UserError())
+		}
+		return nil, fmt.Errorf("failed to decrypt file: %w", err)
+	}
+
+	cipher := aes.NewCipher()
+	computedMac, err := tree.Decrypt(dataKey, cipher)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: error decrypting tree: %w", err)
+	}
+	// Like the sops CLI, a MAC that cannot be decrypted is treated as missing and reported as a mismatch below
+	fileMac, _ := cipher.Decrypt(tree.Metadata.MessageAuthenticationCode, dataKey, tree.Metadata.LastModified.Format(time.RFC3339))
+	if fileMac != computedMac {
+		if fileMac == "" {
+			fileMac = "no MAC"
+		}
+		return nil, fmt.Errorf("failed to decrypt file: MAC mismatch. File has %s, computed %s", fileMac, computedMac)
+	}
+
+	output, err := store.EmitPlainFile(tree.Branches)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt file: error dumping file: %w", err)
+	}
+	return output, nil
+}
+
+func storeForFormat(format string) gosops.Store {
+	switch format {
+	case "yaml":
+		return &yaml.Store{}
+	case "json":
+		return &json.Store{}
+	case "ini":
+		return &ini.Store{}
+	case "dotenv":
+		return &dotenv.Store{}
+	default:
+		return &json.BinaryStore{}
+	}
+}
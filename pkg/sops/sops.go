@@ -18,7 +18,9 @@ package sops
 
 import (
 	"bytes"
+	"context"
 	"fmt"
+	"os"
 	"os/exec"
 	"path/filepath"
 
@@ -37,19 +39,47 @@ var (
 		".ini":  "ini",
 		".env":  "dotenv",
 	}
+
+	formatExtensions = map[string]string{
+		"yaml":   ".yaml",
+		"json":   ".json",
+		"ini":    ".ini",
+		"dotenv": ".env",
+		"binary": ".bin",
+	}
 )
 
 // Decrypt decrypts the given encrypted string. The format (yaml, json, dotenv, init, binary)
-// is determined by the given fileName.
-func (d *Decryptor) Decrypt(fileName string, encrypted string) ([]byte, error) {
-	format := determineFileFormat(fileName)
+// is determined by the given fileName. If keys are given, sops is run without the operator's
+// environment and with an isolated age key file and GnuPG home containing only those keys.
+func (d *Decryptor) Decrypt(fileName string, encrypted string, keys *Keys) ([]byte, error) {
+	return d.DecryptContext(context.Background(), fileName, encrypted, keys)
+}
+
+// DecryptContext decrypts the given encrypted string like Decrypt, killing sops when the given context is done.
+func (d *Decryptor) DecryptContext(ctx context.Context, fileName string, encrypted string, keys *Keys) ([]byte, error) {
+	format := FileFormat(fileName)
 	args := []string{"--decrypt", "--input-type", format, "--output-type", format, "/dev/stdin"}
 	log.V(1).Info("running sops", "args", args)
 
 	// We shell out to SOPS because that way we get better error messages
-	command := exec.Command("sops", args...)
+	command := exec.CommandContext(ctx, "sops", args...)
 	command.Stdin = bytes.NewBufferString(encrypted)
 
+	if keys != nil {
+		dir, err := os.MkdirTemp("", "sops-operator-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create key directory: %w", err)
+		}
+		defer keys.removeDir(dir)
+
+		env, err := keys.environ(dir)
+		if err != nil {
+			return nil, err
+		}
+		command.Env = env
+	}
+
 	output, err := command.Output()
 	if err != nil {
 		if e, ok := err.(*exec.ExitError); ok {
@@ -60,10 +90,21 @@ func (d *Decryptor) Decrypt(fileName string, encrypted string) ([]byte, error) {
 	return output, err
 }
 
-func determineFileFormat(fileName string) string {
+// FileFormat returns the format (yaml, json, dotenv, ini, binary) of the given fileName
+// as determined by its extension.
+func FileFormat(fileName string) string {
 	ext := filepath.Ext(fileName)
 	if format, exists := fileFormats[ext]; exists {
 		return format
 	}
 	return "binary"
 }
+
+// FileNameWithFormat returns the given fileName with an extension appended that makes FileFormat
+// return the given format, e.g. to decrypt data whose key does not reflect its format.
+func FileNameWithFormat(fileName string, format string) string {
+	if format == "" || FileFormat(fileName) == format {
+		return fileName
+	}
+	return fileName + formatExtensions[format]
+}
@@ -0,0 +1,313 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/ProtonMail/go-crypto/openpgp"
+	"github.com/ProtonMail/go-crypto/openpgp/armor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mozilla.org/sops/v3/keyservice"
+)
+
+type decryptor interface {
+	Decrypt(fileName string, encrypted string, keys *Keys) ([]byte, error)
+}
+
+func readTestData(t *testing.T, fileName string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", fileName))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func testDecrypt(t *testing.T, d decryptor) {
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("testdata", "keys.txt"))
+
+	tests := []struct {
+		fileName string
+		want     string
+	}{
+		{fileName: "secret.yaml", want: "user: admin\npassword: s3cr3t\n"},
+		{fileName: "secret.json", want: "{\n\t\"user\": \"admin\",\n\t\"password\": \"s3cr3t\"\n}"},
+		{fileName: "secret.env", want: "USER=admin\nPASSWORD=s3cr3t\n"},
+		{fileName: "secret.ini", want: "[db]\nuser     = admin\npassword = s3cr3t\n\n"},
+		{fileName: "secret.bin", want: "binary s3cr3t"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fileName, func(t *testing.T) {
+			decrypted, err := d.Decrypt(tt.fileName, readTestData(t, tt.fileName), nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, string(decrypted))
+		})
+	}
+}
+
+func TestDecryptor_Decrypt(t *testing.T) {
+	if _, err := exec.LookPath("sops"); err != nil {
+		t.Skip("sops binary not found")
+	}
+	testDecrypt(t, &Decryptor{})
+}
+
+func TestNativeDecryptor_Decrypt(t *testing.T) {
+	testDecrypt(t, &NativeDecryptor{})
+}
+
+func TestNativeDecryptor_DecryptErrors(t *testing.T) {
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("testdata", "keys.txt"))
+
+	tests := []struct {
+		name      string
+		fileName  string
+		encrypted string
+		keyFile   string
+		wantErr   string
+	}{
+		{
+			name:      "not encrypted",
+			fileName:  "plain.yaml",
+			encrypted: "user: admin\n",
+			wantErr:   "failed to decrypt file: sops metadata not found",
+		},
+		{
+			name:      "missing key",
+			fileName:  "secret.yaml",
+			encrypted: readTestData(t, "secret.yaml"),
+			keyFile:   filepath.Join("testdata", "does-not-exist.txt"),
+			wantErr:   "Failed to get the data key required to decrypt the SOPS file.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.keyFile != "" {
+				t.Setenv("SOPS_AGE_KEY_FILE", tt.keyFile)
+			}
+			d := &NativeDecryptor{}
+			_, err := d.Decrypt(tt.fileName, tt.encrypted, nil)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestNativeDecryptor_DecryptWithKeys(t *testing.T) {
+	// keys from the environment must not be used when keys are given
+	t.Setenv("SOPS_AGE_KEY_FILE", filepath.Join("testdata", "keys.txt"))
+
+	otherIdentity, err := age.GenerateX25519Identity()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		wantErr string
+	}{
+		{
+			name: "matching age identity",
+			data: map[string][]byte{"identity.agekey": []byte(readTestData(t, "keys.txt"))},
+		},
+		{
+			name:    "other age identity",
+			data:    map[string][]byte{"identity.agekey": []byte(otherIdentity.String())},
+			wantErr: "no provided age identity could decrypt the data",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := KeysFromSecretData(tt.data)
+			require.NoError(t, err)
+
+			d := &NativeDecryptor{}
+			decrypted, err := d.Decrypt("secret.yaml", readTestData(t, "secret.yaml"), keys)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, "user: admin\npassword: s3cr3t\n", string(decrypted))
+		})
+	}
+}
+
+func TestKeysFromSecretData(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    map[string][]byte
+		wantErr string
+	}{
+		{
+			name: "age identities",
+			data: map[string][]byte{"identity.agekey": []byte(readTestData(t, "keys.txt"))},
+		},
+		{
+			name:    "invalid age identities",
+			data:    map[string][]byte{"identity.agekey": []byte("invalid")},
+			wantErr: `invalid age identities in "identity.agekey"`,
+		},
+		{
+			name:    "invalid PGP key",
+			data:    map[string][]byte{"private.asc": []byte("invalid")},
+			wantErr: `invalid PGP key in "private.asc"`,
+		},
+		{
+			name:    "no keys",
+			data:    map[string][]byte{"README": []byte("nothing to see here")},
+			wantErr: `no keys with suffix ".agekey" or ".asc" found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keys, err := KeysFromSecretData(tt.data)
+			if tt.wantErr != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.NotEmpty(t, keys.AgeIdentities)
+		})
+	}
+}
+
+func TestKeyServiceClient_DecryptPGP(t *testing.T) {
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	require.NoError(t, err)
+
+	var privateKey bytes.Buffer
+	w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(w, nil))
+	require.NoError(t, w.Close())
+
+	var ciphertext bytes.Buffer
+	aw, err := armor.Encode(&ciphertext, "PGP MESSAGE", nil)
+	require.NoError(t, err)
+	pw, err := openpgp.Encrypt(aw, openpgp.EntityList{entity}, nil, nil, nil)
+	require.NoError(t, err)
+	_, err = pw.Write([]byte("data key"))
+	require.NoError(t, err)
+	require.NoError(t, pw.Close())
+	require.NoError(t, aw.Close())
+
+	keys, err := KeysFromSecretData(map[string][]byte{"private.asc": privateKey.Bytes()})
+	require.NoError(t, err)
+
+	client := newKeyServiceClient(keys)
+	rsp, err := client.Decrypt(context.Background(), &keyservice.DecryptRequest{
+		Key:        &keyservice.Key{KeyType: &keyservice.Key_PgpKey{PgpKey: &keyservice.PgpKey{}}},
+		Ciphertext: ciphertext.Bytes(),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "data key", string(rsp.Plaintext))
+}
+
+func TestKeyServiceClient_DecryptOtherKeyTypes(t *testing.T) {
+	keys, err := KeysFromSecretData(map[string][]byte{"identity.agekey": []byte(readTestData(t, "keys.txt"))})
+	require.NoError(t, err)
+
+	client := newKeyServiceClient(keys)
+	_, err = client.Decrypt(context.Background(), &keyservice.DecryptRequest{
+		Key:        &keyservice.Key{KeyType: &keyservice.Key_KmsKey{KmsKey: &keyservice.KmsKey{Arn: "arn:aws:kms:eu-west-1:123456789012:key/test"}}},
+		Ciphertext: []byte("data key"),
+	})
+	assert.EqualError(t, err, "only age and PGP keys can be decrypted with the referenced keys")
+}
+
+func TestKeys_Environ(t *testing.T) {
+	// keys from the operator's environment must not be passed on
+	t.Setenv("SOPS_AGE_KEY", "AGE-SECRET-KEY-OPERATOR")
+	t.Setenv("GNUPGHOME", "/operator/gnupg")
+
+	keys, err := KeysFromSecretData(map[string][]byte{"identity.agekey": []byte(readTestData(t, "keys.txt"))})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	env, err := keys.environ(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"PATH=" + os.Getenv("PATH"),
+		"SOPS_AGE_KEY_FILE=" + filepath.Join(dir, "keys.txt"),
+		"GNUPGHOME=" + filepath.Join(dir, "gnupg"),
+	}, env)
+
+	keys.removeDir(dir)
+	assert.NoDirExists(t, dir)
+}
+
+func TestKeys_RemoveDirStopsGPGAgent(t *testing.T) {
+	if _, err := exec.LookPath("gpgconf"); err != nil {
+		t.Skip("gpgconf binary not found")
+	}
+	entity, err := openpgp.NewEntity("test", "", "test@example.com", nil)
+	require.NoError(t, err)
+	var privateKey bytes.Buffer
+	w, err := armor.Encode(&privateKey, openpgp.PrivateKeyType, nil)
+	require.NoError(t, err)
+	require.NoError(t, entity.SerializePrivate(w, nil))
+	require.NoError(t, w.Close())
+
+	keys, err := KeysFromSecretData(map[string][]byte{"private.asc": privateKey.Bytes()})
+	require.NoError(t, err)
+
+	dir, err := os.MkdirTemp("", "sops-operator-")
+	require.NoError(t, err)
+	env, err := keys.environ(dir)
+	require.NoError(t, err)
+	command := exec.Command("gpgconf", "--list-dirs", "agent-socket")
+	command.Env = env
+	socket, err := command.Output()
+	require.NoError(t, err)
+	require.FileExists(t, string(bytes.TrimSpace(socket)))
+
+	keys.removeDir(dir)
+	assert.NoDirExists(t, dir)
+	assert.NoFileExists(t, string(bytes.TrimSpace(socket)))
+}
+
+func TestFileNameWithFormat(t *testing.T) {
+	tests := []struct {
+		fileName string
+		format   string
+		want     string
+	}{
+		{fileName: "db.yaml", format: "", want: "db.yaml"},
+		{fileName: "db.yaml", format: "yaml", want: "db.yaml"},
+		{fileName: "db.txt", format: "yaml", want: "db.txt.yaml"},
+		{fileName: "config", format: "json", want: "config.json"},
+		{fileName: "settings", format: "dotenv", want: "settings.env"},
+		{fileName: "db.yaml", format: "binary", want: "db.yaml.bin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			got := FileNameWithFormat(tt.fileName, tt.format)
+			assert.Equal(t, tt.want, got)
+			if tt.format != "" {
+				assert.Equal(t, tt.format, FileFormat(got))
+			}
+		})
+	}
+}
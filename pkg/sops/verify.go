@@ -0,0 +1,151 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	gosops "go.mozilla.org/sops/v3"
+)
+
+// encryptedValue matches values encrypted by SOPS.
+var encryptedValue = regexp.MustCompile(`^ENC\[AES256_GCM,data:[^,]*,iv:[^,]+,tag:[^,]+,type:[a-z]+\]$`)
+
+// Verify checks that the given document of the given format (yaml, json, dotenv, ini, binary) is
+// encrypted by SOPS without decrypting it: it must contain SOPS metadata with a MAC and at least
+// one master key, and every value must be encrypted unless the metadata's unencrypted suffix or
+// regex, or encrypted suffix or regex, exclude it from encryption. It returns a description of
+// each problem found. Descriptions contain the paths of offending values, but never the values.
+func Verify(format string, document []byte) []string {
+	tree, err := storeForFormat(format).LoadEncryptedFile(document)
+	if err != nil {
+		if errors.Is(err, gosops.MetadataNotFound) {
+			return []string{"sops metadata not found"}
+		}
+		// SOPS does not export an error for metadata without keys
+		if err.Error() == "No keys found in file" {
+			return []string{"sops metadata has no keys"}
+		}
+		// Other errors are not returned since they may contain values of the document
+		return []string{fmt.Sprintf("unable to load %s document", format)}
+	}
+
+	var problems []string
+	if tree.Metadata.MessageAuthenticationCode == "" {
+		problems = append(problems, "sops metadata has no MAC")
+	} else if !encryptedValue.MatchString(tree.Metadata.MessageAuthenticationCode) {
+		problems = append(problems, "sops metadata has an invalid MAC")
+	}
+
+	v := &verifier{metadata: tree.Metadata}
+	for _, branch := range tree.Branches {
+		v.verifyBranch(branch, nil, nil)
+	}
+	return append(problems, v.problems...)
+}
+
+type verifier struct {
+	metadata gosops.Metadata
+	problems []string
+}
+
+// verifyBranch verifies the values of the given branch. The path consists of the keys leading to
+// the branch as used by SOPS to decide whether values are encrypted, the display path additionally
+// contains list indices.
+func (v *verifier) verifyBranch(branch gosops.TreeBranch, path []string, displayPath []string) {
+	for _, item := range branch {
+		if comment, ok := item.Key.(gosops.Comment); ok {
+			v.verifyValue(comment.Value, path, append(displayPath, "#"), "comment")
+			continue
+		}
+		key := fmt.Sprint(item.Key)
+		v.verifyValue(item.Value, append(path, key), append(displayPath, key), "value")
+	}
+}
+
+func (v *verifier) verifyValue(value interface{}, path []string, displayPath []string, kind string) {
+	switch value := value.(type) {
+	case gosops.TreeBranch:
+		v.verifyBranch(value, path, displayPath)
+	case []interface{}:
+		for i, item := range value {
+			v.verifyValue(item, path, append(displayPath, "["+strconv.Itoa(i)+"]"), kind)
+		}
+	case nil:
+	default:
+		if !v.shouldBeEncrypted(path) {
+			return
+		}
+		if s, ok := value.(string); ok && encryptedValue.MatchString(s) {
+			return
+		}
+		v.problems = append(v.problems, fmt.Sprintf("%s at %s is not encrypted", kind, joinPath(displayPath)))
+	}
+}
+
+// shouldBeEncrypted returns whether SOPS encrypts values at the given path, following the same rules.
+func (v *verifier) shouldBeEncrypted(path []string) bool {
+	encrypted := true
+	if v.metadata.UnencryptedSuffix != "" && anyMatches(path, func(p string) bool { return strings.HasSuffix(p, v.metadata.UnencryptedSuffix) }) {
+		encrypted = false
+	}
+	if v.metadata.EncryptedSuffix != "" {
+		encrypted = anyMatches(path, func(p string) bool { return strings.HasSuffix(p, v.metadata.EncryptedSuffix) })
+	}
+	if v.metadata.UnencryptedRegex != "" && anyMatches(path, regexMatcher(v.metadata.UnencryptedRegex)) {
+		encrypted = false
+	}
+	if v.metadata.EncryptedRegex != "" {
+		encrypted = anyMatches(path, regexMatcher(v.metadata.EncryptedRegex))
+	}
+	return encrypted
+}
+
+func anyMatches(path []string, matches func(string) bool) bool {
+	for _, p := range path {
+		if matches(p) {
+			return true
+		}
+	}
+	return false
+}
+
+func regexMatcher(expr string) func(string) bool {
+	return func(p string) bool {
+		matched, _ := regexp.MatchString(expr, p)
+		return matched
+	}
+}
+
+// joinPath joins the given display path, e.g. 'database.hosts[0]'.
+func joinPath(path []string) string {
+	var b strings.Builder
+	for i, p := range path {
+		if i > 0 && !strings.HasPrefix(p, "[") {
+			b.WriteString(".")
+		}
+		b.WriteString(p)
+	}
+	if b.Len() == 0 {
+		return "top level"
+	}
+	return b.String()
+}
@@ -0,0 +1,133 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package sops
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	encValue     = "ENC[AES256_GCM,data:LWKUHf8=,iv:+Sqjxski2TlkcP6MmD17OqA/RiRdGEINUiM0STssHt8=,tag:OEWyU8VWuBPckMdK0TYK1w==,type:str]"
+	lastModified = "    lastmodified: \"2026-10-17T00:24:35Z\"\n"
+	ageKeys      = `    age:
+        - recipient: age1s6w5fy8f3vm9fhhyv8vh39vdkjtu7wyuakzq0g7vua5ump5pdftq72valh
+          enc: |
+            -----BEGIN AGE ENCRYPTED FILE-----
+            -----END AGE ENCRYPTED FILE-----
+`
+)
+
+func TestVerify_TestData(t *testing.T) {
+	for _, fileName := range []string{"secret.yaml", "secret.json", "secret.env", "secret.ini", "secret.bin"} {
+		t.Run(fileName, func(t *testing.T) {
+			assert.Empty(t, Verify(FileFormat(fileName), []byte(readTestData(t, fileName))))
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	metadata := func(lines ...string) string {
+		return "sops:\n" + ageKeys + lastModified + "    mac: " + encValue + "\n" + strings.Join(lines, "")
+	}
+
+	tests := []struct {
+		name     string
+		format   string
+		document string
+		want     []string
+	}{
+		{
+			name:     "encrypted",
+			format:   "yaml",
+			document: "db:\n    hosts:\n        - " + encValue + "\n    password: " + encValue + "\n" + metadata(),
+		},
+		{
+			name:     "plaintext",
+			format:   "yaml",
+			document: "password: s3cr3t\n",
+			want:     []string{"sops metadata not found"},
+		},
+		{
+			name:     "invalid document",
+			format:   "json",
+			document: "{",
+			want:     []string{"unable to load json document"},
+		},
+		{
+			name:     "plaintext values",
+			format:   "yaml",
+			document: "db:\n    hosts:\n        - " + encValue + "\n        - db.example.com\n    port: 5432\n    password: s3cr3t\n" + metadata(),
+			want: []string{
+				"value at db.hosts[1] is not encrypted",
+				"value at db.port is not encrypted",
+				"value at db.password is not encrypted",
+			},
+		},
+		{
+			name:     "plaintext comment",
+			format:   "yaml",
+			document: "# s3cr3t\npassword: " + encValue + "\n" + metadata(),
+			want:     []string{"comment at # is not encrypted"},
+		},
+		{
+			name:     "unencrypted suffix",
+			format:   "yaml",
+			document: "host_unencrypted: db.example.com\npassword: " + encValue + "\n" + metadata("    unencrypted_suffix: _unencrypted\n"),
+		},
+		{
+			name:     "encrypted regex",
+			format:   "yaml",
+			document: "host: db.example.com\npassword: " + encValue + "\nuser: admin\n" + metadata("    encrypted_regex: ^pass\n"),
+		},
+		{
+			name:     "unencrypted regex",
+			format:   "yaml",
+			document: "host: db.example.com\npassword: s3cr3t\n" + metadata("    unencrypted_regex: ^host$\n"),
+			want:     []string{"value at password is not encrypted"},
+		},
+		{
+			name:     "missing MAC",
+			format:   "yaml",
+			document: "password: " + encValue + "\nsops:\n" + ageKeys + lastModified,
+			want:     []string{"sops metadata has no MAC"},
+		},
+		{
+			name:     "plaintext MAC",
+			format:   "yaml",
+			document: "password: " + encValue + "\nsops:\n" + ageKeys + lastModified + "    mac: s3cr3t\n",
+			want:     []string{"sops metadata has an invalid MAC"},
+		},
+		{
+			name:     "no keys",
+			format:   "yaml",
+			document: "password: " + encValue + "\nsops:\n" + lastModified + "    mac: " + encValue + "\n",
+			want:     []string{"sops metadata has no keys"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problems := Verify(tt.format, []byte(tt.document))
+			assert.Equal(t, tt.want, problems)
+			for _, problem := range problems {
+				assert.NotContains(t, problem, "s3cr3t")
+			}
+		})
+	}
+}
@@ -0,0 +1,207 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
+)
+
+const (
+	// CheckSopsSecretDecryptionPath is the path the SopsSecret decryption check webhook is served at.
+	CheckSopsSecretDecryptionPath = "/validate-craftypath-github-io-v1alpha1-sopssecret-decryption"
+
+	// DecryptionCheckLabel enables the decryption check for SopsSecrets in namespaces labeled with value 'enabled'.
+	DecryptionCheckLabel = "craftypath.github.io/decryption-check"
+	decryptionCheckValue = "enabled"
+
+	// maxPendingDecryptions limits the decryptions that continue in the background after their check timed out.
+	maxPendingDecryptions = 4
+)
+
+// The webhook configuration is maintained in config/webhook/decryption_check.yaml instead of being generated,
+// since it limits the webhook to namespaces enabling the check with a namespaceSelector.
+//+kubebuilder:rbac:groups="",resources=namespaces,verbs=get
+
+// Decryptor decrypts Sops-encrypted data.
+type Decryptor interface {
+	Decrypt(fileName string, encrypted string, keys *sops.Keys) ([]byte, error)
+}
+
+// ContextDecryptor is a Decryptor that stops decrypting when the given context is done.
+type ContextDecryptor interface {
+	DecryptContext(ctx context.Context, fileName string, encrypted string, keys *sops.Keys) ([]byte, error)
+}
+
+// SopsSecretDecryptionChecker denies SopsSecrets the operator cannot decrypt, so that decryption
+// failures are reported to whoever applies a SopsSecret instead of only in its events and status.
+// Only SopsSecrets in namespaces that opt in with the DecryptionCheckLabel are checked.
+type SopsSecretDecryptionChecker struct {
+	// Reader reads namespaces and key Secrets. It should not be cached, so that no informers are
+	// started for the rarely needed objects.
+	Reader    client.Reader
+	Decryptor Decryptor
+	// Timeout limits the time decrypting a SopsSecret may take.
+	Timeout time.Duration
+	// FailOpen allows SopsSecrets whose check cannot be completed, e.g. because decryption times out
+	// or the key Secret does not exist yet. Otherwise, such SopsSecrets are denied.
+	FailOpen bool
+
+	decoder *admission.Decoder
+	// pending holds a slot for each decryption running in the background.
+	pending     chan struct{}
+	pendingOnce sync.Once
+}
+
+// SetupWithManager registers the checker with the webhook server of the given manager.
+func (c *SopsSecretDecryptionChecker) SetupWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(CheckSopsSecretDecryptionPath, &webhook.Admission{Handler: c})
+	return nil
+}
+
+// InjectDecoder injects the decoder for admission requests.
+func (c *SopsSecretDecryptionChecker) InjectDecoder(decoder *admission.Decoder) error {
+	c.decoder = decoder
+	return nil
+}
+
+// Handle decrypts the SopsSecret of the given admission request if its namespace enables the check.
+func (c *SopsSecretDecryptionChecker) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	sopsSecret, err := decodeChanged(c.decoder, req)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if sopsSecret == nil {
+		return admission.Allowed("")
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := c.Reader.Get(ctx, types.NamespacedName{Name: req.Namespace}, namespace); err != nil {
+		return c.incomplete(ctx, fmt.Errorf("unable to get namespace %q: %w", req.Namespace, err))
+	}
+	if namespace.Labels[DecryptionCheckLabel] != decryptionCheckValue {
+		return admission.Allowed("")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	keys, err := c.decryptionKeys(ctx, sopsSecret)
+	if err != nil {
+		return c.incomplete(ctx, err)
+	}
+
+	var errs field.ErrorList
+	for _, document := range encryptedDocuments(sopsSecret) {
+		if err := c.decrypt(ctx, document, keys); err != nil {
+			if ctx.Err() != nil {
+				return c.incomplete(ctx, fmt.Errorf("decryption timed out after %s", c.Timeout))
+			}
+			errs = append(errs, field.Forbidden(document.path, err.Error()))
+		}
+	}
+	if len(errs) > 0 {
+		return denied(apierrors.NewInvalid(craftypathgithubiov1alpha1.GroupVersion.WithKind("SopsSecret").GroupKind(), sopsSecret.Name, errs))
+	}
+	return admission.Allowed("")
+}
+
+// decrypt decrypts the given document, giving up when the given context is done. Decryptors that cannot
+// be canceled continue in the background until they complete, and at most maxPendingDecryptions of them
+// run at the same time, so that repeated timeouts cannot pile up decryptions.
+func (c *SopsSecretDecryptionChecker) decrypt(ctx context.Context, document encryptedDocument, keys *sops.Keys) error {
+	fileName := sops.FileNameWithFormat(document.name, document.format)
+	if decryptor, ok := c.Decryptor.(ContextDecryptor); ok {
+		_, err := decryptor.DecryptContext(ctx, fileName, string(document.content), keys)
+		return err
+	}
+
+	c.pendingOnce.Do(func() {
+		c.pending = make(chan struct{}, maxPendingDecryptions)
+	})
+	select {
+	case c.pending <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		defer func() { <-c.pending }()
+		_, err := c.Decryptor.Decrypt(fileName, string(document.content), keys)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// decryptionKeys returns the keys referenced by the given SopsSecret, if any.
+func (c *SopsSecretDecryptionChecker) decryptionKeys(ctx context.Context, sopsSecret *craftypathgithubiov1alpha1.SopsSecret) (*sops.Keys, error) {
+	if sopsSecret.Spec.Decryption == nil || sopsSecret.Spec.Decryption.KeyRef == nil {
+		return nil, nil
+	}
+
+	keyRef := sopsSecret.Spec.Decryption.KeyRef
+	keySecret := &corev1.Secret{}
+	if err := c.Reader.Get(ctx, types.NamespacedName{Namespace: sopsSecret.Namespace, Name: keyRef.Name}, keySecret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, fmt.Errorf("key secret %q not found", keyRef.Name)
+		}
+		return nil, fmt.Errorf("unable to get key secret %q: %w", keyRef.Name, err)
+	}
+
+	keys, err := sops.KeysFromSecretData(keySecret.Data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read keys from secret %q: %w", keyRef.Name, err)
+	}
+	return keys, nil
+}
+
+// incomplete returns the response for a request whose check could not be completed with the given error.
+func (c *SopsSecretDecryptionChecker) incomplete(ctx context.Context, err error) admission.Response {
+	log.FromContext(ctx).Info("unable to check decryption", "error", err.Error(), "failOpen", c.FailOpen)
+	if c.FailOpen {
+		return admission.Allowed("").WithWarnings("decryption check skipped: " + err.Error())
+	}
+	return admission.Denied("decryption check failed: " + err.Error())
+}
@@ -0,0 +1,262 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
+)
+
+type FakeDecryptor struct {
+	fileNames []string
+	keys      *sops.Keys
+	delay     time.Duration
+	errs      map[string]error
+}
+
+func (f *FakeDecryptor) Decrypt(fileName string, encrypted string, keys *sops.Keys) ([]byte, error) {
+	f.fileNames = append(f.fileNames, fileName)
+	f.keys = keys
+	time.Sleep(f.delay)
+	if err := f.errs[fileName]; err != nil {
+		return nil, err
+	}
+	return []byte("unencrypted"), nil
+}
+
+// FakeContextDecryptor blocks until the context of each decryption is done.
+type FakeContextDecryptor struct {
+	FakeDecryptor
+	canceled int
+}
+
+func (f *FakeContextDecryptor) DecryptContext(ctx context.Context, fileName string, encrypted string, keys *sops.Keys) ([]byte, error) {
+	f.fileNames = append(f.fileNames, fileName)
+	<-ctx.Done()
+	f.canceled++
+	return nil, ctx.Err()
+}
+
+func newNamespace(labels map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "test-namespace", Labels: labels}}
+}
+
+func newSopsSecretDecryptionChecker(t *testing.T, decryptor Decryptor, objs ...runtime.Object) *SopsSecretDecryptionChecker {
+	t.Helper()
+	s := runtime.NewScheme()
+	utilruntime.Must(scheme.AddToScheme(s))
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+	decoder, err := admission.NewDecoder(s)
+	require.NoError(t, err)
+	c := &SopsSecretDecryptionChecker{
+		Reader:    fake.NewClientBuilder().WithScheme(s).WithRuntimeObjects(objs...).Build(),
+		Decryptor: decryptor,
+		Timeout:   time.Second,
+	}
+	require.NoError(t, c.InjectDecoder(decoder))
+	return c
+}
+
+func TestSopsSecretDecryptionChecker(t *testing.T) {
+	enabled := newNamespace(map[string]string{DecryptionCheckLabel: "enabled"})
+
+	tests := []struct {
+		name          string
+		namespace     *corev1.Namespace
+		spec          v1alpha1.SopsSecretSpec
+		decryptor     *FakeDecryptor
+		failOpen      bool
+		wantAllowed   bool
+		wantFileNames []string
+		wantCauses    []metav1.StatusCause
+		wantMessage   string
+		wantWarnings  []string
+	}{
+		{
+			name:        "namespace not enabled",
+			namespace:   newNamespace(nil),
+			spec:        v1alpha1.SopsSecretSpec{StringData: map[string]string{"test.yaml": "encrypted"}},
+			decryptor:   &FakeDecryptor{errs: map[string]error{"test.yaml": errors.New("failed to decrypt file")}},
+			wantAllowed: true,
+		},
+		{
+			name:      "decrypted",
+			namespace: enabled,
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"test.yaml": "encrypted", "settings": "encrypted"},
+				Data:       map[string][]byte{"cert.der": []byte("encrypted")},
+				Options:    map[string]v1alpha1.SopsSecretEntryOptions{"settings": {Format: v1alpha1.EntryFormatJSON}},
+			},
+			decryptor:     &FakeDecryptor{},
+			wantAllowed:   true,
+			wantFileNames: []string{"settings.json", "test.yaml", "cert.der"},
+		},
+		{
+			name:      "decryption failed",
+			namespace: enabled,
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"test.yaml": "encrypted", "other.yaml": "encrypted"},
+			},
+			decryptor: &FakeDecryptor{errs: map[string]error{"test.yaml": errors.New("failed to decrypt file: no key could decrypt the data key")}},
+			wantCauses: []metav1.StatusCause{
+				{
+					Type:    metav1.CauseType(field.ErrorTypeForbidden),
+					Message: "Forbidden: failed to decrypt file: no key could decrypt the data key",
+					Field:   "spec.stringData[test.yaml]",
+				},
+			},
+		},
+		{
+			name:      "manifest decryption failed",
+			namespace: enabled,
+			spec:      v1alpha1.SopsSecretSpec{Manifest: "encrypted"},
+			decryptor: &FakeDecryptor{errs: map[string]error{"manifest.yaml": errors.New("failed to decrypt file")}},
+			wantCauses: []metav1.StatusCause{
+				{
+					Type:    metav1.CauseType(field.ErrorTypeForbidden),
+					Message: "Forbidden: failed to decrypt file",
+					Field:   "spec.manifest",
+				},
+			},
+		},
+		{
+			name:        "timeout failing closed",
+			namespace:   enabled,
+			spec:        v1alpha1.SopsSecretSpec{StringData: map[string]string{"test.yaml": "encrypted"}},
+			decryptor:   &FakeDecryptor{delay: 2 * time.Second},
+			wantMessage: "decryption check failed: decryption timed out after 1s",
+		},
+		{
+			name:         "timeout failing open",
+			namespace:    enabled,
+			spec:         v1alpha1.SopsSecretSpec{StringData: map[string]string{"test.yaml": "encrypted"}},
+			decryptor:    &FakeDecryptor{delay: 2 * time.Second},
+			failOpen:     true,
+			wantAllowed:  true,
+			wantWarnings: []string{"decryption check skipped: decryption timed out after 1s"},
+		},
+		{
+			name:      "key secret not found",
+			namespace: enabled,
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"test.yaml": "encrypted"},
+				Decryption: &v1alpha1.SopsSecretDecryption{KeyRef: &corev1.LocalObjectReference{Name: "sops-keys"}},
+			},
+			decryptor:   &FakeDecryptor{},
+			wantMessage: `decryption check failed: key secret "sops-keys" not found`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+				Spec:       tt.spec,
+			}
+			c := newSopsSecretDecryptionChecker(t, tt.decryptor, tt.namespace)
+			c.FailOpen = tt.failOpen
+
+			response := c.Handle(context.Background(), newRequest(t, admissionv1.Create, sopsSecret, nil))
+			assert.Equal(t, tt.wantAllowed, response.Allowed)
+			assert.Equal(t, tt.wantWarnings, response.Warnings)
+			if tt.wantFileNames != nil {
+				assert.Equal(t, tt.wantFileNames, tt.decryptor.fileNames)
+			}
+			if tt.wantCauses != nil {
+				require.NotNil(t, response.Result.Details)
+				assert.Equal(t, metav1.StatusReasonInvalid, response.Result.Reason)
+				assert.Equal(t, tt.wantCauses, response.Result.Details.Causes)
+			}
+			if tt.wantMessage != "" {
+				assert.Equal(t, tt.wantMessage, string(response.Result.Reason))
+			}
+		})
+	}
+}
+
+func TestSopsSecretDecryptionChecker_KeyRef(t *testing.T) {
+	keySecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "sops-keys", Namespace: "test-namespace"},
+		Data:       map[string][]byte{"key.agekey": []byte(readTestData(t, "keys.txt"))},
+	}
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData: map[string]string{"test.yaml": "encrypted"},
+			Decryption: &v1alpha1.SopsSecretDecryption{KeyRef: &corev1.LocalObjectReference{Name: "sops-keys"}},
+		},
+	}
+	decryptor := &FakeDecryptor{}
+	c := newSopsSecretDecryptionChecker(t, decryptor, newNamespace(map[string]string{DecryptionCheckLabel: "enabled"}), keySecret)
+
+	response := c.Handle(context.Background(), newRequest(t, admissionv1.Create, sopsSecret, nil))
+	assert.True(t, response.Allowed)
+	require.NotNil(t, decryptor.keys)
+	assert.NotEmpty(t, decryptor.keys.AgeIdentities)
+}
+
+func TestSopsSecretDecryptionChecker_Timeout(t *testing.T) {
+	enabled := newNamespace(map[string]string{DecryptionCheckLabel: "enabled"})
+	sopsSecret := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+		Spec:       v1alpha1.SopsSecretSpec{StringData: map[string]string{"test.yaml": "encrypted"}},
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		decryptor := &FakeContextDecryptor{}
+		c := newSopsSecretDecryptionChecker(t, decryptor, enabled)
+		c.Timeout = 10 * time.Millisecond
+
+		response := c.Handle(context.Background(), newRequest(t, admissionv1.Create, sopsSecret, nil))
+		assert.False(t, response.Allowed)
+		assert.Equal(t, "decryption check failed: decryption timed out after 10ms", string(response.Result.Reason))
+		assert.Equal(t, 1, decryptor.canceled)
+	})
+
+	t.Run("too many pending decryptions", func(t *testing.T) {
+		decryptor := &FakeDecryptor{}
+		c := newSopsSecretDecryptionChecker(t, decryptor, enabled)
+		c.Timeout = 10 * time.Millisecond
+		c.pendingOnce.Do(func() {
+			c.pending = make(chan struct{}, maxPendingDecryptions)
+		})
+		for i := 0; i < maxPendingDecryptions; i++ {
+			c.pending <- struct{}{}
+		}
+
+		response := c.Handle(context.Background(), newRequest(t, admissionv1.Create, sopsSecret, nil))
+		assert.False(t, response.Allowed)
+		assert.Equal(t, "decryption check failed: decryption timed out after 10ms", string(response.Result.Reason))
+		assert.Empty(t, decryptor.fileNames)
+	})
+}
@@ -0,0 +1,184 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	craftypathgithubiov1alpha1 "github.com/craftypath/sops-operator/api/v1alpha1"
+	"github.com/craftypath/sops-operator/pkg/sops"
+)
+
+// ValidateSopsSecretPath is the path the SopsSecret validating webhook is served at.
+const ValidateSopsSecretPath = "/validate-craftypath-github-io-v1alpha1-sopssecret"
+
+//+kubebuilder:webhook:path=/validate-craftypath-github-io-v1alpha1-sopssecret,mutating=false,failurePolicy=fail,sideEffects=None,groups=craftypath.github.io,resources=sopssecrets,verbs=create;update,versions=v1alpha1,name=vsopssecret.craftypath.github.io,admissionReviewVersions=v1
+
+// SopsSecretValidator rejects SopsSecrets containing data that is not encrypted by SOPS, so that
+// plaintext is noticed before it is stored rather than when decryption fails during reconciliation.
+// SopsSecrets of all versions are validated as v1alpha1, to which the API server converts them.
+type SopsSecretValidator struct {
+	decoder *admission.Decoder
+}
+
+// SetupWithManager registers the validator with the webhook server of the given manager.
+func (v *SopsSecretValidator) SetupWithManager(mgr ctrl.Manager) error {
+	mgr.GetWebhookServer().Register(ValidateSopsSecretPath, &webhook.Admission{Handler: v})
+	return nil
+}
+
+// InjectDecoder injects the decoder for admission requests.
+func (v *SopsSecretValidator) InjectDecoder(decoder *admission.Decoder) error {
+	v.decoder = decoder
+	return nil
+}
+
+// Handle validates the SopsSecret of the given admission request.
+func (v *SopsSecretValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	if req.Operation != admissionv1.Create && req.Operation != admissionv1.Update {
+		return admission.Allowed("")
+	}
+
+	sopsSecret, err := decodeChanged(v.decoder, req)
+	if err != nil {
+		return admission.Errored(http.StatusBadRequest, err)
+	}
+	if sopsSecret == nil {
+		return admission.Allowed("")
+	}
+
+	if errs := validateEncrypted(sopsSecret); len(errs) > 0 {
+		return denied(apierrors.NewInvalid(craftypathgithubiov1alpha1.GroupVersion.WithKind("SopsSecret").GroupKind(), sopsSecret.Name, errs))
+	}
+	return admission.Allowed("")
+}
+
+// decodeChanged decodes the SopsSecret of the given create or update request. It returns nil if the
+// SopsSecret does not need to be validated because its spec is unchanged or it is being deleted, so that
+// SopsSecrets remain updatable without changing their spec, e.g. to add or remove finalizers.
+func decodeChanged(decoder *admission.Decoder, req admission.Request) (*craftypathgithubiov1alpha1.SopsSecret, error) {
+	sopsSecret := &craftypathgithubiov1alpha1.SopsSecret{}
+	if err := decoder.Decode(req, sopsSecret); err != nil {
+		return nil, err
+	}
+	if sopsSecret.DeletionTimestamp != nil {
+		return nil, nil
+	}
+	if req.Operation == admissionv1.Update {
+		old := &craftypathgithubiov1alpha1.SopsSecret{}
+		if err := decoder.DecodeRaw(req.OldObject, old); err != nil {
+			return nil, err
+		}
+		if equality.Semantic.DeepEqual(old.Spec, sopsSecret.Spec) {
+			return nil, nil
+		}
+	}
+	return sopsSecret, nil
+}
+
+// validateEncrypted verifies that all entries and the manifest of the given SopsSecret are encrypted by SOPS.
+func validateEncrypted(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) field.ErrorList {
+	var errs field.ErrorList
+	for _, document := range encryptedDocuments(sopsSecret) {
+		for _, problem := range sops.Verify(document.format, document.content) {
+			errs = append(errs, field.Forbidden(document.path, "must be encrypted with sops: "+problem))
+		}
+	}
+	return errs
+}
+
+// encryptedDocument is an entry or the manifest of a SopsSecret.
+type encryptedDocument struct {
+	path    *field.Path
+	name    string
+	format  string
+	content []byte
+}
+
+// entryFormat returns the format of the given entry of the SopsSecret, which is determined
+// by the extension of its name unless overridden in its options.
+func entryFormat(sopsSecret *craftypathgithubiov1alpha1.SopsSecret, fileName string) string {
+	if format := sopsSecret.Spec.Options[fileName].Format; format != "" {
+		return string(format)
+	}
+	return sops.FileFormat(fileName)
+}
+
+// encryptedDocuments returns the entries of StringData and Data sorted by key, followed by the manifest.
+func encryptedDocuments(sopsSecret *craftypathgithubiov1alpha1.SopsSecret) []encryptedDocument {
+	var documents []encryptedDocument
+	specPath := field.NewPath("spec")
+
+	names := make([]string, 0, len(sopsSecret.Spec.StringData))
+	for name := range sopsSecret.Spec.StringData {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		documents = append(documents, encryptedDocument{
+			path:    specPath.Child("stringData").Key(name),
+			name:    name,
+			format:  entryFormat(sopsSecret, name),
+			content: []byte(sopsSecret.Spec.StringData[name]),
+		})
+	}
+
+	names = make([]string, 0, len(sopsSecret.Spec.Data))
+	for name := range sopsSecret.Spec.Data {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		documents = append(documents, encryptedDocument{
+			path:    specPath.Child("data").Key(name),
+			name:    name,
+			format:  entryFormat(sopsSecret, name),
+			content: sopsSecret.Spec.Data[name],
+		})
+	}
+
+	if sopsSecret.Spec.Manifest != "" {
+		documents = append(documents, encryptedDocument{
+			path:    specPath.Child("manifest"),
+			name:    "manifest.yaml",
+			format:  "yaml",
+			content: []byte(sopsSecret.Spec.Manifest),
+		})
+	}
+	return documents
+}
+
+// denied returns a response denying the request with the status of the given error.
+func denied(err apierrors.APIStatus) admission.Response {
+	status := err.Status()
+	return admission.Response{
+		AdmissionResponse: admissionv1.AdmissionResponse{
+			Allowed: false,
+			Result:  &status,
+		},
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright The SOPS Operator Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	"github.com/craftypath/sops-operator/api/v1alpha1"
+)
+
+func readTestData(t *testing.T, fileName string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "pkg", "sops", "testdata", fileName))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func newRequest(t *testing.T, operation admissionv1.Operation, sopsSecret *v1alpha1.SopsSecret, old *v1alpha1.SopsSecret) admission.Request {
+	t.Helper()
+	raw := func(obj *v1alpha1.SopsSecret) runtime.RawExtension {
+		if obj == nil {
+			return runtime.RawExtension{}
+		}
+		obj.APIVersion = v1alpha1.GroupVersion.String()
+		obj.Kind = "SopsSecret"
+		data, err := json.Marshal(obj)
+		require.NoError(t, err)
+		return runtime.RawExtension{Raw: data}
+	}
+	return admission.Request{
+		AdmissionRequest: admissionv1.AdmissionRequest{
+			Operation: operation,
+			Name:      sopsSecret.Name,
+			Namespace: sopsSecret.Namespace,
+			Object:    raw(sopsSecret),
+			OldObject: raw(old),
+		},
+	}
+}
+
+func newSopsSecretValidator(t *testing.T) *SopsSecretValidator {
+	t.Helper()
+	s := runtime.NewScheme()
+	utilruntime.Must(v1alpha1.AddToScheme(s))
+	decoder, err := admission.NewDecoder(s)
+	require.NoError(t, err)
+	v := &SopsSecretValidator{}
+	require.NoError(t, v.InjectDecoder(decoder))
+	return v
+}
+
+func TestSopsSecretValidator(t *testing.T) {
+	encrypted := readTestData(t, "secret.yaml")
+
+	tests := []struct {
+		name       string
+		spec       v1alpha1.SopsSecretSpec
+		wantCauses []metav1.StatusCause
+	}{
+		{
+			name: "encrypted",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"secret.yaml": encrypted, "secret.env": readTestData(t, "secret.env")},
+				Data:       map[string][]byte{"secret.bin": []byte(readTestData(t, "secret.bin"))},
+			},
+		},
+		{
+			name: "format override",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"secret": encrypted},
+				Options:    map[string]v1alpha1.SopsSecretEntryOptions{"secret": {Format: v1alpha1.EntryFormatYAML}},
+			},
+		},
+		{
+			name: "plaintext entries",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"secret.yaml": encrypted, "plain.yaml": "password: s3cr3t\n"},
+				Data:       map[string][]byte{"plain.bin": []byte("s3cr3t")},
+			},
+			wantCauses: []metav1.StatusCause{
+				{
+					Type:    metav1.CauseType(field.ErrorTypeForbidden),
+					Message: "Forbidden: must be encrypted with sops: sops metadata not found",
+					Field:   "spec.stringData[plain.yaml]",
+				},
+				{
+					Type:    metav1.CauseType(field.ErrorTypeForbidden),
+					Message: "Forbidden: must be encrypted with sops: unable to load binary document",
+					Field:   "spec.data[plain.bin]",
+				},
+			},
+		},
+		{
+			name: "plaintext value",
+			spec: v1alpha1.SopsSecretSpec{
+				StringData: map[string]string{"secret.yaml": "admin: s3cr3t\n" + encrypted},
+			},
+			wantCauses: []metav1.StatusCause{
+				{
+					Type:    metav1.CauseType(field.ErrorTypeForbidden),
+					Message: "Forbidden: must be encrypted with sops: value at admin is not encrypted",
+					Field:   "spec.stringData[secret.yaml]",
+				},
+			},
+		},
+		{
+			name: "plaintext manifest",
+			spec: v1alpha1.SopsSecretSpec{
+				Manifest: "apiVersion: v1\nkind: Secret\nstringData:\n  password: s3cr3t\n",
+			},
+			wantCauses: []metav1.StatusCause{
+				{
+					Type:    metav1.CauseType(field.ErrorTypeForbidden),
+					Message: "Forbidden: must be encrypted with sops: sops metadata not found",
+					Field:   "spec.manifest",
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sopsSecret := &v1alpha1.SopsSecret{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+				Spec:       tt.spec,
+			}
+
+			response := newSopsSecretValidator(t).Handle(context.Background(), newRequest(t, admissionv1.Create, sopsSecret, nil))
+			if tt.wantCauses == nil {
+				assert.True(t, response.Allowed)
+				return
+			}
+			assert.False(t, response.Allowed)
+			require.NotNil(t, response.Result)
+			require.NotNil(t, response.Result.Details)
+			assert.Equal(t, metav1.StatusReasonInvalid, response.Result.Reason)
+			assert.Equal(t, tt.wantCauses, response.Result.Details.Causes)
+			assert.NotContains(t, response.Result.Message, "s3cr3t")
+		})
+	}
+}
+
+func TestSopsSecretValidator_Update(t *testing.T) {
+	old := &v1alpha1.SopsSecret{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-secret", Namespace: "test-namespace"},
+		Spec: v1alpha1.SopsSecretSpec{
+			StringData: map[string]string{"plain.yaml": "password: s3cr3t\n"},
+		},
+	}
+	v := newSopsSecretValidator(t)
+
+	// updates not changing the spec are allowed, e.g. for adding finalizers to existing SopsSecrets
+	sopsSecret := old.DeepCopy()
+	sopsSecret.Finalizers = []string{"craftypath.github.io/sopssecret"}
+	response := v.Handle(context.Background(), newRequest(t, admissionv1.Update, sopsSecret, old))
+	assert.True(t, response.Allowed)
+
+	sopsSecret = old.DeepCopy()
+	sopsSecret.Spec.StringData["other.yaml"] = "password: s3cr3t\n"
+	response = v.Handle(context.Background(), newRequest(t, admissionv1.Update, sopsSecret, old))
+	assert.False(t, response.Allowed)
+
+	now := metav1.Now()
+	sopsSecret.DeletionTimestamp = &now
+	response = v.Handle(context.Background(), newRequest(t, admissionv1.Update, sopsSecret, old))
+	assert.True(t, response.Allowed)
+}